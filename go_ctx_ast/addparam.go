@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// runAddParam implements the add-ctx-param subcommand: the function enclosing at
+// (file.go:123) takes a ctx context.Context first parameter, its context.TODO()
+// calls use it, and its callers pass theirs.
+func runAddParam(configs []buildConfig, at string, patterns []string) int {
+	filename, line, err := parseFileLine(at)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	pkgs, status := loadWholeProgram("add-ctx-param", configs, patterns, true)
+	if pkgs == nil {
+		return status
+	}
+	results, err := addContextParam(pkgs, filename, line)
+	if err != nil {
+		log.Printf("add-ctx-param %s: %v", at, err)
+		return 1
+	}
+	return applyResults(results)
+}
+
+// parseFileLine parses a position given as file.go:line, returning the absolute
+// file name.
+func parseFileLine(at string) (string, int, error) {
+	i := strings.LastIndex(at, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("add-ctx-param %q: want file.go:line", at)
+	}
+	line, err := strconv.Atoi(at[i+1:])
+	if err != nil || line <= 0 {
+		return "", 0, fmt.Errorf("add-ctx-param %q: bad line %q", at, at[i+1:])
+	}
+	filename, err := filepath.Abs(at[:i])
+	if err != nil {
+		return "", 0, err
+	}
+	return filename, line, nil
+}
+
+// addContextParam computes the edits that add a ctx context.Context parameter to
+// the function declared around filename:line in pkgs, which must all come from
+// one loadPackages call. Its context.TODO() calls become ctx. Every caller passes
+// the context it has in scope, or context.TODO() if it has none, which is logged
+// so the parameter can be added there next.
+func addContextParam(pkgs []*packages.Package, filename string, line int) ([]fileResult, error) {
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages loaded")
+	}
+	es := newEditSet(pkgs[0].Fset)
+	units := migrationUnits(pkgs)
+	var unit migrationUnit
+	var fd *ast.FuncDecl
+	for _, u := range units {
+		if es.fset.File(u.file.Pos()).Name() != filename {
+			continue
+		}
+		for _, decl := range u.file.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && es.fset.Position(d.Pos()).Line <= line && es.fset.Position(d.End()).Line >= line {
+				unit, fd = u, d
+			}
+		}
+	}
+	if fd == nil {
+		return nil, fmt.Errorf("no function declared at line %d of %s", line, filename)
+	}
+	info := unit.pkg.TypesInfo
+	fn, ok := info.Defs[fd.Name].(*types.Func)
+	if !ok {
+		return nil, fmt.Errorf("%s has no type information", fd.Name.Name)
+	}
+	key := declKey(es.fset, fn)
+	if why := addParamExcluded(pkgs, unit, units, fd, fn); why != "" {
+		return nil, fmt.Errorf("%s: %s", fd.Name.Name, why)
+	}
+
+	q := es.contextName(unit.file)
+	text := "ctx " + q + ".Context"
+	pos := es.offset(fd.Type.Params.Opening) + 1
+	if len(fd.Type.Params.List) > 0 {
+		pos = es.offset(fd.Type.Params.List[0].Pos())
+		text += ", "
+	}
+	es.note(fd.Name.Pos(), fmt.Sprintf("%s: added parameter ctx %s.Context", fd.Name.Name, q), edit{start: pos, end: pos, text: text})
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && isContextTODO(call) && resolvesToContextPkg(info, call, false) {
+			es.note(call.Pos(), "context.TODO() → ctx", edit{start: es.offset(call.Pos()), end: es.offset(call.End()), text: "ctx"})
+			return false
+		}
+		return true
+	})
+
+	for _, u := range units {
+		info := u.pkg.TypesInfo
+		walkScopes(info, u.file, false, func(call *ast.CallExpr, site callSite) bool {
+			id := calleeIdent(call)
+			if id == nil {
+				return true
+			}
+			if called, ok := info.Uses[id].(*types.Func); !ok || declKey(es.fset, called) != key {
+				return true
+			}
+			arg := site.ctxExpr
+			switch {
+			case site.decl == fd:
+				arg = "ctx"
+			case arg == "":
+				arg = es.contextName(u.file) + ".TODO()"
+				p := es.fset.Position(call.Pos())
+				log.Printf("[TODO] %s:%d: no context in scope; run add-ctx-param %s:%d next", p.Filename, p.Line, p.Filename, p.Line)
+			}
+			pos := es.offset(call.Lparen) + 1
+			text := arg
+			if len(call.Args) > 0 {
+				pos = es.offset(call.Args[0].Pos())
+				text += ", "
+			}
+			es.note(call.Pos(), fmt.Sprintf("%s(...) → passes %s", types.ExprString(call.Fun), arg), edit{start: pos, end: pos, text: text})
+			return true
+		})
+	}
+	return es.results(), nil
+}
+
+// addParamExcluded returns why the signature of fn, declared by fd in u, cannot
+// change, or "".
+func addParamExcluded(pkgs []*packages.Package, u migrationUnit, units []migrationUnit, fd *ast.FuncDecl, fn *types.Func) string {
+	sig := fn.Type().(*types.Signature)
+	key := declKey(u.pkg.Fset, fn)
+	switch {
+	case fd.Body == nil:
+		return "it has no body"
+	case sig.Recv() == nil && (fd.Name.Name == "init" || fd.Name.Name == "main" && fn.Pkg().Name() == "main"):
+		return "it cannot take parameters"
+	case sig.Params().Len() > 0 && isContextValue(sig.Params().At(0).Type()):
+		return "it already takes a context"
+	case declaresName(u.pkg.TypesInfo, fd, "ctx"):
+		return "it already uses the name ctx"
+	}
+	if sig.Recv() != nil {
+		ifaceMethods := importedInterfaceMethods(pkgs)
+		for _, pkg := range pkgs {
+			scope := pkg.Types.Scope()
+			for _, name := range scope.Names() {
+				if iface, ok := scope.Lookup(name).Type().Underlying().(*types.Interface); ok {
+					for i := 0; i < iface.NumMethods(); i++ {
+						ifaceMethods[iface.Method(i).Name()] = true
+					}
+				}
+			}
+		}
+		if ifaceMethods[fd.Name.Name] {
+			return "method " + fd.Name.Name + " may implement an interface"
+		}
+	}
+	// Every use must be a direct call with one argument per parameter; a function
+	// value may have to match a function type elsewhere.
+	for _, u := range units {
+		info := u.pkg.TypesInfo
+		called := map[*ast.Ident]bool{}
+		var why string
+		ast.Inspect(u.file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr); ok && info.Types[sel.X].IsType() {
+				return true // a method expression
+			}
+			if id := calleeIdent(call); id != nil {
+				called[id] = true
+				if f, ok := info.Uses[id].(*types.Func); ok && declKey(u.pkg.Fset, f) == key && len(call.Args) == 1 {
+					if _, multi := info.TypeOf(call.Args[0]).(*types.Tuple); multi {
+						p := u.pkg.Fset.Position(call.Pos())
+						why = fmt.Sprintf("the call at %s:%d passes a multi-value expression", p.Filename, p.Line)
+					}
+				}
+			}
+			return true
+		})
+		ast.Inspect(u.file, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && !called[id] && why == "" {
+				if f, ok := info.Uses[id].(*types.Func); ok && declKey(u.pkg.Fset, f) == key {
+					p := u.pkg.Fset.Position(id.Pos())
+					why = fmt.Sprintf("it is used as a function value at %s:%d", p.Filename, p.Line)
+				}
+			}
+			return why == ""
+		})
+		if why != "" {
+			return why
+		}
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddContextParam(t *testing.T) {
+	dir := t.TempDir()
+	storeFile := filepath.Join(dir, "store.go")
+	apiFile := filepath.Join(dir, "api.go")
+	testFile := filepath.Join(dir, "store_test.go")
+	srcs := map[string]string{
+		storeFile: `package store
+
+import "context"
+
+func Load(id int) string {
+	get(context.TODO(), id)
+	if id > 0 {
+		return Load(id-1)
+	}
+	return ""
+}
+
+func get(ctx context.Context, id int) {}
+
+func Save() {}
+
+var hook = Save
+
+func Flush() {}
+`,
+		apiFile: `package api
+
+import (
+	"net/http"
+
+	"example.com/store"
+)
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	store.Load(1)
+}
+
+func Warm() {
+	store.Load(2)
+}
+`,
+		testFile: `package store
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	if Load(3) != "" {
+		t.Fail()
+	}
+}
+
+var flush = Flush
+`,
+	}
+	for filename, src := range srcs {
+		assert.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+	}
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/store", "example.com/api"}, map[string]map[string]string{
+		"example.com/store": {storeFile: srcs[storeFile]},
+		"example.com/api":   {apiFile: srcs[apiFile]},
+	})
+	// the test variant of store, as a load with tests returns it next to store
+	variant := testPackages(t, fset, []string{"example.com/store"}, map[string]map[string]string{
+		"example.com/store": {storeFile: srcs[storeFile], testFile: srcs[testFile]},
+	})[0]
+	variant.ID = "example.com/store [example.com/store.test]"
+	pkgs = append(pkgs, variant)
+
+	results, err := addContextParam(pkgs, storeFile, 6)
+	assert.NoError(t, err)
+	out := map[string]string{}
+	for _, res := range results {
+		var edits []edit
+		for _, f := range res.findings {
+			edits = append(edits, f.edit)
+		}
+		b, err := applyEdits([]byte(srcs[res.filename]), edits)
+		assert.NoError(t, err)
+		out[res.filename] = string(b)
+	}
+	assert.Equal(t, `package store
+
+import "context"
+
+func Load(ctx context.Context, id int) string {
+	get(ctx, id)
+	if id > 0 {
+		return Load(ctx, id-1)
+	}
+	return ""
+}
+
+func get(ctx context.Context, id int) {}
+
+func Save() {}
+
+var hook = Save
+
+func Flush() {}
+`, out[storeFile])
+	assert.Equal(t, `package api
+
+import (
+	"context"
+	"net/http"
+
+	"example.com/store"
+)
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	store.Load(r.Context(), 1)
+}
+
+func Warm() {
+	store.Load(context.TODO(), 2)
+}
+`, out[apiFile])
+
+	assert.Equal(t, `package store
+
+import "context"
+import "testing"
+
+func TestLoad(t *testing.T) {
+	if Load(context.TODO(), 3) != "" {
+		t.Fail()
+	}
+}
+
+var flush = Flush
+`, out[testFile])
+
+	_, err = addContextParam(pkgs, storeFile, 19)
+	assert.EqualError(t, err, "Flush: it is used as a function value at "+testFile+":11")
+	_, err = addContextParam(pkgs, storeFile, 15)
+	assert.EqualError(t, err, "Save: it is used as a function value at "+storeFile+":17")
+	_, err = addContextParam(pkgs, storeFile, 13)
+	assert.EqualError(t, err, "get: it already takes a context")
+	_, err = addContextParam(pkgs, storeFile, 3)
+	assert.Error(t, err)
+}
+
+func TestParseFileLine(t *testing.T) {
+	filename, line, err := parseFileLine("/src/svc/run.go:123")
+	assert.NoError(t, err)
+	assert.Equal(t, "/src/svc/run.go", filename)
+	assert.Equal(t, 123, line)
+
+	_, _, err = parseFileLine("run.go")
+	assert.Error(t, err)
+	_, _, err = parseFileLine("run.go:x")
+	assert.Error(t, err)
+}
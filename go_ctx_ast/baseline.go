@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// baselineVersion is the format version written to baseline files.
+const baselineVersion = 1
+
+// baselineFile is the committed list of context.TODO() calls that `check`
+// tolerates. Calls are identified by package directory, function and source line
+// rather than by position, so unrelated edits that move code around do not make
+// old calls look new.
+type baselineFile struct {
+	Version int             `json:"version"`
+	Sites   []baselineEntry `json:"sites"`
+}
+
+type baselineEntry struct {
+	Package  string `json:"package"`  // directory, relative to the repository root
+	Function string `json:"function"` // as funcName; empty at package level
+	Snippet  string `json:"snippet"`  // the call's source line, whitespace collapsed
+	Count    int    `json:"count"`
+}
+
+type baselineKey struct {
+	pkg, fn, snippet string
+}
+
+// todoCall is a context.TODO() call still in the code.
+type todoCall struct {
+	pos token.Position
+	key baselineKey
+}
+
+// todoCalls returns every context.TODO() call left in results, rewritable or not,
+// keyed for the baseline. Suppressed calls are intentional and not included. root
+// is the directory package paths are relative to.
+func todoCalls(root string, results []fileResult) ([]todoCall, error) {
+	var calls []todoCall
+	for _, res := range results {
+		type site struct {
+			pos token.Position
+			fn  string
+		}
+		sites := map[int]site{}
+		for _, f := range append(append([]finding(nil), res.findings...), res.inconsistent...) {
+			for _, pos := range f.todoSites() {
+				sites[pos.Offset] = site{pos, f.fn}
+			}
+		}
+		for _, u := range res.unresolved {
+			if u.reason != reasonSuppressed {
+				sites[u.pos.Offset] = site{u.pos, u.fn}
+			}
+		}
+		if len(sites) == 0 {
+			continue
+		}
+		lines, err := readLines(res.filename)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := packageDir(root, res.filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sites {
+			calls = append(calls, todoCall{pos: s.pos, key: baselineKey{dir, s.fn, snippet(lines, s.pos.Line)}})
+		}
+	}
+	sort.Slice(calls, func(i, j int) bool { return positionLess(calls[i].pos, calls[j].pos) })
+	return calls, nil
+}
+
+// readLines returns the lines of filename.
+func readLines(filename string) ([][]byte, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Split(src, []byte("\n")), nil
+}
+
+// packageDir returns the slash-separated directory of filename relative to root.
+func packageDir(root, filename string) (string, error) {
+	dir, err := filepath.Rel(root, filepath.Dir(realPath(filename)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(dir), nil
+}
+
+// snippet returns line n of lines with its whitespace collapsed, or "" past the end.
+func snippet(lines [][]byte, n int) string {
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.Join(strings.Fields(string(lines[n-1])), " ")
+}
+
+// readBaseline returns the number of tolerated calls per key.
+func readBaseline(path string) (map[baselineKey]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var bf baselineFile
+	if err := json.Unmarshal(data, &bf); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if bf.Version != baselineVersion {
+		return nil, fmt.Errorf("%s: unsupported baseline version %d", path, bf.Version)
+	}
+	counts := map[baselineKey]int{}
+	for _, e := range bf.Sites {
+		counts[baselineKey{e.Package, e.Function, e.Snippet}] += e.Count
+	}
+	return counts, nil
+}
+
+// encodeBaseline returns the baseline for calls, sorted so that it diffs well.
+func encodeBaseline(calls []todoCall) ([]byte, error) {
+	counts := map[baselineKey]int{}
+	for _, c := range calls {
+		counts[c.key]++
+	}
+	bf := baselineFile{Version: baselineVersion, Sites: []baselineEntry{}}
+	for k, n := range counts {
+		bf.Sites = append(bf.Sites, baselineEntry{Package: k.pkg, Function: k.fn, Snippet: k.snippet, Count: n})
+	}
+	sort.Slice(bf.Sites, func(i, j int) bool {
+		a, b := bf.Sites[i], bf.Sites[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Function != b.Function {
+			return a.Function < b.Function
+		}
+		return a.Snippet < b.Snippet
+	})
+	data, err := json.MarshalIndent(bf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// newCalls returns the calls the baseline does not cover, and how many tolerated
+// calls are gone. When a key has more calls than the baseline allows, the last
+// ones (by position) count as new.
+func newCalls(baseline map[baselineKey]int, calls []todoCall) ([]todoCall, int) {
+	seen := map[baselineKey]int{}
+	var added []todoCall
+	for _, c := range calls {
+		seen[c.key]++
+		if seen[c.key] > baseline[c.key] {
+			added = append(added, c)
+		}
+	}
+	gone := 0
+	for k, n := range baseline {
+		if n > seen[k] {
+			gone += n - seen[k]
+		}
+	}
+	return added, gone
+}
+
+// runBaseline implements `check` and `baseline update` on the analysed results
+// and returns the exit status. check fails only if there are calls the baseline
+// does not cover.
+func runBaseline(update bool, path string, results []fileResult) int {
+	root, err := repoRoot()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	calls, err := todoCalls(root, results)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if update {
+		data, err := encodeBaseline(calls)
+		if err == nil {
+			err = os.WriteFile(path, data, 0o644)
+		}
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		fmt.Printf("✅ %s: %d context.TODO() calls\n", path, len(calls))
+		return 0
+	}
+
+	baseline, err := readBaseline(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("no baseline at %s; create it with `baseline update`", path)
+		return 1
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	added, gone := newCalls(baseline, calls)
+	for _, c := range added {
+		in := ""
+		if c.key.fn != "" {
+			in = " in " + c.key.fn
+		}
+		fmt.Printf("[NEW] %s:%d: context.TODO()%s is not in the baseline\n", c.pos.Filename, c.pos.Line, in)
+	}
+	if gone > 0 {
+		log.Printf("%d context.TODO() calls in the baseline are gone; run `baseline update` to lock that in", gone)
+	}
+	if len(added) > 0 {
+		return 1
+	}
+	return 0
+}
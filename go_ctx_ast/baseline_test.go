@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseline(t *testing.T) {
+	root := t.TempDir()
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "svc"), 0o755))
+	file := filepath.Join(root, "svc", "a.go")
+	src := "package svc\n\nfunc A() {\n\tuse(context.TODO())\n\tuse(context.TODO())\n}\n\nfunc B(ctx context.Context) {\n\t\tuse(  context.TODO())\n}\n"
+	assert.NoError(t, os.WriteFile(file, []byte(src), 0o644))
+
+	at := func(line, offset int) token.Position {
+		return token.Position{Filename: file, Line: line, Offset: offset}
+	}
+	results := []fileResult{{
+		filename:   file,
+		findings:   []finding{{pos: at(9, 80), fn: "svc.B", text: "ctx"}},
+		unresolved: []unresolvedSite{{pos: at(4, 30), fn: "svc.A"}, {pos: at(5, 50), fn: "svc.A"}},
+	}}
+	calls, err := todoCalls(root, results)
+	assert.NoError(t, err)
+	assert.Equal(t, []todoCall{
+		{pos: at(4, 30), key: baselineKey{"svc", "svc.A", "use(context.TODO())"}},
+		{pos: at(5, 50), key: baselineKey{"svc", "svc.A", "use(context.TODO())"}},
+		{pos: at(9, 80), key: baselineKey{"svc", "svc.B", "use( context.TODO())"}},
+	}, calls)
+
+	data, err := encodeBaseline(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "version": 1,
+  "sites": [
+    {
+      "package": "svc",
+      "function": "svc.A",
+      "snippet": "use(context.TODO())",
+      "count": 2
+    },
+    {
+      "package": "svc",
+      "function": "svc.B",
+      "snippet": "use( context.TODO())",
+      "count": 1
+    }
+  ]
+}
+`, string(data))
+	path := filepath.Join(root, "baseline.json")
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	baseline, err := readBaseline(path)
+	assert.NoError(t, err)
+
+	// the same calls on other lines are still covered
+	moved := []todoCall{
+		{pos: at(14, 30), key: calls[0].key},
+		{pos: at(15, 50), key: calls[1].key},
+		{pos: at(19, 80), key: calls[2].key},
+	}
+	added, gone := newCalls(baseline, moved)
+	assert.Empty(t, added)
+	assert.Zero(t, gone)
+
+	// a third call in A is new, and the one in B is gone
+	more := []todoCall{calls[0], calls[1], {pos: at(6, 60), key: calls[0].key}}
+	added, gone = newCalls(baseline, more)
+	assert.Equal(t, []todoCall{{pos: at(6, 60), key: calls[0].key}}, added)
+	assert.Equal(t, 1, gone)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version": 2, "sites": []}`), 0o644))
+	_, err = readBaseline(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// buildConfig is one GOOS/GOARCH/tags combination the packages are loaded with.
+// The zero platform means the go tool's defaults.
+type buildConfig struct {
+	goos   string
+	goarch string
+	cgo    bool
+	tags   string
+}
+
+func (bc buildConfig) String() string {
+	s := "default"
+	if bc.goos != "" {
+		s = bc.goos + "/" + bc.goarch
+		if bc.cgo {
+			s += "/cgo"
+		}
+	}
+	if bc.tags != "" {
+		s += " tags=" + bc.tags
+	}
+	return s
+}
+
+// packagesConfig returns the packages.Config that loads this configuration.
+func (bc buildConfig) packagesConfig() *packages.Config {
+	cfg := &packages.Config{
+		Mode:  packages.LoadSyntax, // parse + type-check + syntax
+		Dir:   ".",                 // the working directory; see loadGroups
+		Tests: flagTests,
+	}
+	if bc.goos != "" {
+		cfg.Env = append(os.Environ(), "GOOS="+bc.goos, "GOARCH="+bc.goarch)
+		if bc.cgo {
+			cfg.Env = append(cfg.Env, "CGO_ENABLED=1")
+		}
+	}
+	if bc.tags != "" {
+		cfg.BuildFlags = []string{"-tags=" + bc.tags}
+	}
+	return cfg
+}
+
+// parseBuildConfigs expands -platforms and -tags into the configurations to load.
+func parseBuildConfigs(platforms, tags string) ([]buildConfig, error) {
+	if strings.TrimSpace(platforms) == "" {
+		return []buildConfig{{tags: tags}}, nil
+	}
+	var configs []buildConfig
+	for _, p := range strings.Split(platforms, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] != "cgo") {
+			return nil, fmt.Errorf("invalid platform %q: want GOOS/GOARCH or GOOS/GOARCH/cgo", p)
+		}
+		configs = append(configs, buildConfig{goos: parts[0], goarch: parts[1], cgo: len(parts) == 3, tags: tags})
+	}
+	return configs, nil
+}
+
+// mergedResults combines the per-file results of several build configurations.
+type mergedResults map[string]*mergedFile
+
+type mergedFile struct {
+	configs    int                    // configurations that compiled the file
+	sites      map[int][]finding      // findings by edit offset, one per agreeing configuration
+	unresolved map[int]unresolvedSite // calls left in place in some configuration, by offset
+	err        error
+}
+
+// add records the results of one build configuration.
+func (m mergedResults) add(results []fileResult) {
+	for _, res := range results {
+		mf := m[res.filename]
+		if mf == nil {
+			mf = &mergedFile{sites: map[int][]finding{}, unresolved: map[int]unresolvedSite{}}
+			m[res.filename] = mf
+		}
+		mf.configs++
+		if mf.err == nil {
+			mf.err = res.err
+		}
+		for _, f := range res.findings {
+			mf.sites[f.edit.start] = append(mf.sites[f.edit.start], f)
+		}
+		for _, u := range res.unresolved {
+			mf.unresolved[u.pos.Offset] = u
+		}
+	}
+}
+
+// results returns one fileResult per file. A site is kept only if every
+// configuration that compiled the file chose the same replacement for it;
+// the others are reported as inconsistent.
+func (m mergedResults) results() []fileResult {
+	var all []fileResult
+	for filename, mf := range m {
+		res := fileResult{filename: filename, err: mf.err}
+		for _, fs := range mf.sites {
+			consistent := len(fs) == mf.configs
+			for _, f := range fs[1:] {
+				if f.edit.text != fs[0].edit.text {
+					consistent = false
+				}
+			}
+			if consistent {
+				res.findings = append(res.findings, fs[0])
+			} else {
+				res.inconsistent = append(res.inconsistent, fs[0])
+			}
+		}
+		for _, u := range mf.unresolved {
+			res.unresolved = append(res.unresolved, u)
+		}
+		sort.Slice(res.unresolved, func(i, j int) bool { return res.unresolved[i].pos.Offset < res.unresolved[j].pos.Offset })
+		sortFindings(res.findings)
+		sortFindings(res.inconsistent)
+		all = append(all, res)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].filename < all[j].filename })
+	return all
+}
+
+// sortFindings orders findings by position within their file.
+func sortFindings(fs []finding) {
+	sort.Slice(fs, func(i, j int) bool { return fs[i].edit.start < fs[j].edit.start })
+}
+
+// usablePackages reports packages with errors and returns the ones that can still be
+// processed. Packages that failed to list or parse, or have no type information, are
+// skipped; packages with only type errors are kept and analysed with partial types.
+func usablePackages(pkgs []*packages.Package, bc buildConfig) ([]*packages.Package, int) {
+	var usable []*packages.Package
+	skipped := 0
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) == 0 {
+			usable = append(usable, pkg)
+			continue
+		}
+		fatal := pkg.Types == nil || pkg.TypesInfo == nil || len(pkg.Syntax) == 0
+		for _, e := range pkg.Errors {
+			if e.Kind != packages.TypeError {
+				fatal = true
+			}
+		}
+		if fatal {
+			skipped++
+			log.Printf("[SKIP] %s (%s): %d errors, package not processed", pkg.ID, bc, len(pkg.Errors))
+		} else {
+			usable = append(usable, pkg)
+			log.Printf("[PARTIAL] %s (%s): %d type errors, only rewriting sites that do not depend on them", pkg.ID, bc, len(pkg.Errors))
+		}
+		for _, e := range pkg.Errors {
+			log.Printf("    %v", e)
+		}
+	}
+	return usable, skipped
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuildConfigs(t *testing.T) {
+	configs, err := parseBuildConfigs("", "integration")
+	assert.NoError(t, err)
+	assert.Equal(t, []buildConfig{{tags: "integration"}}, configs)
+
+	configs, err = parseBuildConfigs("linux/amd64, darwin/arm64,linux/arm64/cgo", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []buildConfig{
+		{goos: "linux", goarch: "amd64"},
+		{goos: "darwin", goarch: "arm64"},
+		{goos: "linux", goarch: "arm64", cgo: true},
+	}, configs)
+	assert.Equal(t, "linux/arm64/cgo", configs[2].String())
+
+	_, err = parseBuildConfigs("linux", "")
+	assert.Error(t, err)
+	_, err = parseBuildConfigs("linux/amd64/nocgo", "")
+	assert.Error(t, err)
+}
+
+func TestMergedResults(t *testing.T) {
+	site := func(start int, text string) finding {
+		return finding{text: text, edit: edit{start: start, end: start + 14, text: text}}
+	}
+	m := mergedResults{}
+	// linux compiles both files, windows only a.go.
+	m.add([]fileResult{
+		{filename: "a.go", findings: []finding{site(10, "ctx"), site(50, "ctx"), site(90, "r.Context()")}},
+		{filename: "a_linux.go", findings: []finding{site(10, "ctx")}},
+	})
+	m.add([]fileResult{
+		{filename: "a.go", findings: []finding{site(10, "ctx"), site(90, "ctx")}},
+	})
+
+	results := m.results()
+	assert.Len(t, results, 2)
+	assert.Equal(t, "a.go", results[0].filename)
+	assert.Equal(t, []finding{site(10, "ctx")}, results[0].findings)
+	assert.Equal(t, []finding{site(50, "ctx"), site(90, "r.Context()")}, results[0].inconsistent)
+	assert.Equal(t, "a_linux.go", results[1].filename)
+	assert.Equal(t, []finding{site(10, "ctx")}, results[1].findings)
+}
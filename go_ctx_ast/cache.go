@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// analysisCache keeps the results of the default mode per package across runs
+// (-cache), so CI and pre-commit runs only type-check the packages that changed.
+//
+// A package's key hashes the tool and the flags that affect the analysis, the
+// package's files, and for each import either its key, if it is analysed in the
+// same run (escape analysis reads the bodies of its functions), or its export data.
+type analysisCache struct {
+	dir     string
+	salt    string
+	exports map[string]string // hashes of export data files
+}
+
+// cacheEntry is the stored result of one package.
+type cacheEntry struct {
+	Files     []cachedFile      `json:"files"`
+	Generated []cachedGenerated `json:"generated,omitempty"`
+}
+
+type cachedFile struct {
+	Filename   string          `json:"filename"`
+	Findings   []cachedFinding `json:"findings,omitempty"`
+	Unresolved []cachedSite    `json:"unresolved,omitempty"`
+}
+
+type cachedFinding struct {
+	Pos    token.Position `json:"pos"`
+	Text   string         `json:"text"`
+	Start  int            `json:"start"`
+	End    int            `json:"end"`
+	Edit   string         `json:"edit"`
+	Fn     string         `json:"fn,omitempty"`
+	Alts   []string       `json:"alts,omitempty"`
+	Review string         `json:"review,omitempty"`
+
+	Span  bool             `json:"span,omitempty"`
+	Sites []token.Position `json:"sites,omitempty"`
+}
+
+type cachedSite struct {
+	Pos    token.Position `json:"pos"`
+	Fn     string         `json:"fn,omitempty"`
+	Reason string         `json:"reason"`
+	Detail string         `json:"detail,omitempty"`
+}
+
+type cachedGenerated struct {
+	Filename  string `json:"filename"`
+	Generator string `json:"generator"`
+	TODOs     int    `json:"todos"`
+}
+
+// openCache returns the cache under $XDG_CACHE_HOME/go_ctx_ast (or the platform's
+// user cache directory) for runs in build configuration bc.
+func openCache(bc buildConfig) (*analysisCache, error) {
+	root, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, "go_ctx_ast")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	salt, err := cacheSalt(bc)
+	if err != nil {
+		return nil, err
+	}
+	return &analysisCache{dir: dir, salt: salt, exports: map[string]string{}}, nil
+}
+
+// cacheSalt hashes the running executable and the configuration of the analysis.
+func cacheSalt(bc buildConfig) (string, error) {
+	h := sha256.New()
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "\n%s\ntests=%t no-goroutines=%t test-context=%t receiver-ctx=%t ctx-accessors=%s include-generated=%t exclude=%s\n",
+		bc, flagTests, flagNoGoroutines, flagTestContext, flagReceiverCtx, flagCtxAccessors, flagIncludeGenerated, flagExclude.String())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// keys returns the cache keys of roots, by package ID. Packages that failed to
+// list or whose files cannot be read have no key.
+func (c *analysisCache) keys(roots []*packages.Package) map[string]string {
+	isRoot := map[string]bool{}
+	for _, p := range roots {
+		isRoot[p.ID] = true
+	}
+	keys := map[string]string{}
+	var key func(p *packages.Package) string
+	key = func(p *packages.Package) string {
+		if k, ok := keys[p.ID]; ok {
+			return k
+		}
+		keys[p.ID] = ""
+		if len(p.Errors) > 0 {
+			return ""
+		}
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\n%s\n", c.salt, p.ID)
+		files := append(append([]string(nil), p.GoFiles...), p.CompiledGoFiles...)
+		for _, filename := range files {
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				return ""
+			}
+			fmt.Fprintf(h, "file %s %d\n", filename, len(content))
+			h.Write(content)
+		}
+		var paths []string
+		for path := range p.Imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			imp := p.Imports[path]
+			var k string
+			if isRoot[imp.ID] {
+				k = key(imp)
+			} else {
+				k = c.exportHash(imp)
+			}
+			if k == "" {
+				return ""
+			}
+			fmt.Fprintf(h, "import %s %s\n", path, k)
+		}
+		keys[p.ID] = hex.EncodeToString(h.Sum(nil))
+		return keys[p.ID]
+	}
+	for _, p := range roots {
+		key(p)
+	}
+	for id, k := range keys {
+		if k == "" {
+			delete(keys, id)
+		}
+	}
+	return keys
+}
+
+// exportHash returns the hash of p's export data, or "" if it cannot be read.
+func (c *analysisCache) exportHash(p *packages.Package) string {
+	if p.ExportFile == "" {
+		if p.ID == "unsafe" || p.ID == "C" {
+			return p.ID // no export data
+		}
+		return ""
+	}
+	if h, ok := c.exports[p.ExportFile]; ok {
+		return h
+	}
+	content, err := os.ReadFile(p.ExportFile)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	c.exports[p.ExportFile] = hex.EncodeToString(sum[:])
+	return c.exports[p.ExportFile]
+}
+
+func (c *analysisCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get returns the entry stored under key.
+func (c *analysisCache) get(key string) (cacheEntry, bool) {
+	var e cacheEntry
+	data, err := os.ReadFile(c.path(key))
+	if err != nil || json.Unmarshal(data, &e) != nil {
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// put stores e under key, replacing the file atomically so concurrent runs never
+// read half an entry.
+func (c *analysisCache) put(key string, e cacheEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// newCacheEntry converts the results and generated files of one package.
+func newCacheEntry(results []fileResult, gen generatedStats) cacheEntry {
+	e := cacheEntry{Files: []cachedFile{}}
+	for _, res := range results {
+		cf := cachedFile{Filename: res.filename}
+		for _, f := range res.findings {
+			cf.Findings = append(cf.Findings, cachedFinding{
+				Pos: f.pos, Text: f.text, Start: f.edit.start, End: f.edit.end, Edit: f.edit.text,
+				Fn: f.fn, Alts: f.alts, Review: f.review, Span: f.span, Sites: f.sites,
+			})
+		}
+		for _, u := range res.unresolved {
+			cf.Unresolved = append(cf.Unresolved, cachedSite{Pos: u.pos, Fn: u.fn, Reason: u.reason, Detail: u.detail})
+		}
+		e.Files = append(e.Files, cf)
+	}
+	for filename, gf := range gen {
+		e.Generated = append(e.Generated, cachedGenerated{Filename: filename, Generator: gf.generator, TODOs: gf.todos})
+	}
+	sort.Slice(e.Generated, func(i, j int) bool { return e.Generated[i].Filename < e.Generated[j].Filename })
+	return e
+}
+
+// results converts e back.
+func (e cacheEntry) results() ([]fileResult, generatedStats) {
+	var results []fileResult
+	for _, cf := range e.Files {
+		res := fileResult{filename: cf.Filename}
+		for _, f := range cf.Findings {
+			res.findings = append(res.findings, finding{
+				pos: f.Pos, text: f.Text, edit: edit{start: f.Start, end: f.End, text: f.Edit},
+				fn: f.Fn, alts: f.Alts, review: f.Review, span: f.Span, sites: f.Sites,
+			})
+		}
+		for _, u := range cf.Unresolved {
+			res.unresolved = append(res.unresolved, unresolvedSite{pos: u.Pos, fn: u.Fn, reason: u.Reason, detail: u.Detail})
+		}
+		results = append(results, res)
+	}
+	gen := generatedStats{}
+	for _, g := range e.Generated {
+		gen[g.Filename] = generatedFile{generator: g.Generator, todos: g.TODOs}
+	}
+	return results, gen
+}
+
+// loadCached loads patterns in bc and analyses them like processPackages, taking
+// the results of unchanged packages from cache. Only the packages that changed,
+// and those of the run they import, are type-checked. It returns the number of
+// packages skipped because of errors, as usablePackages does.
+func loadCached(cache *analysisCache, bc buildConfig, patterns []string) ([]fileResult, generatedStats, int, error) {
+	cfg := bc.packagesConfig()
+	cfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports | packages.NeedDeps | packages.NeedExportFile
+	roots, err := loadPackages(cfg, patterns...)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(roots) == 0 {
+		return nil, nil, 0, fmt.Errorf("no Go packages found")
+	}
+	keys := cache.keys(roots)
+
+	// Test variants share files with their package: a path is reused only if all
+	// of its variants are.
+	entries := map[string]cacheEntry{}
+	stale := map[string]bool{}
+	total := 0
+	for _, p := range roots {
+		if strings.HasSuffix(p.ID, ".test") {
+			continue // synthesized test main package
+		}
+		total++
+		e, ok := cache.get(keys[p.ID])
+		if keys[p.ID] == "" || !ok {
+			stale[p.PkgPath] = true
+			continue
+		}
+		entries[p.ID] = e
+	}
+	var results []fileResult
+	gen := generatedStats{}
+	hits := 0
+	for _, p := range roots {
+		if e, ok := entries[p.ID]; ok && !stale[p.PkgPath] {
+			res, g := e.results()
+			results = append(results, res...)
+			gen.merge(g)
+			hits++
+		}
+	}
+	log.Printf("[CACHE] %s: %d of %d packages unchanged", bc, hits, total)
+	if len(stale) == 0 {
+		return results, gen, 0, nil
+	}
+
+	// Load the changed packages with the packages of the run they import.
+	need := map[string]bool{}
+	isRoot := map[string]bool{}
+	for _, p := range roots {
+		isRoot[p.ID] = true
+	}
+	var visit func(p *packages.Package)
+	visit = func(p *packages.Package) {
+		if need[p.PkgPath] {
+			return
+		}
+		need[p.PkgPath] = true
+		for _, imp := range p.Imports {
+			if isRoot[imp.ID] {
+				visit(imp)
+			}
+		}
+	}
+	for _, p := range roots {
+		if stale[p.PkgPath] {
+			visit(p)
+		}
+	}
+	// By directory rather than import path, so that each loads from its module.
+	dirs := map[string]string{}
+	for _, p := range roots {
+		if len(p.GoFiles) > 0 && need[p.PkgPath] {
+			dirs[p.PkgPath] = filepath.Dir(p.GoFiles[0])
+		}
+	}
+	var paths []string
+	for path := range need {
+		if dir, ok := dirs[path]; ok {
+			path = dir
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	pkgs, err := loadPackages(bc.packagesConfig(), paths...)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	usable, skipped := usablePackages(pkgs, bc)
+	var changed []*packages.Package
+	for _, p := range usable {
+		if stale[p.PkgPath] {
+			changed = append(changed, p)
+		}
+	}
+	fresh, freshGen := processPackages(changed, newFuncIndex(usable), flagJobs)
+	results = append(results, fresh...)
+	gen.merge(freshGen)
+
+	// Store each package's files, attributed like processPackages does: to the
+	// first variant that contains them.
+	owner := map[string]*packages.Package{}
+	for _, p := range changed {
+		if strings.HasSuffix(p.ID, ".test") {
+			continue
+		}
+		for _, file := range p.Syntax {
+			if filename := p.Fset.File(file.Pos()).Name(); owner[filename] == nil {
+				owner[filename] = p
+			}
+		}
+	}
+	byPkg := map[*packages.Package][]fileResult{}
+	for _, res := range fresh {
+		byPkg[owner[res.filename]] = append(byPkg[owner[res.filename]], res)
+	}
+	genByPkg := map[*packages.Package]generatedStats{}
+	for filename, gf := range freshGen {
+		if p := owner[filename]; p != nil {
+			if genByPkg[p] == nil {
+				genByPkg[p] = generatedStats{}
+			}
+			genByPkg[p][filename] = gf
+		}
+	}
+	for _, p := range changed {
+		key := keys[p.ID]
+		if key == "" || len(p.Errors) > 0 || strings.HasSuffix(p.ID, ".test") {
+			continue // packages with errors are analysed again next time
+		}
+		if err := cache.put(key, newCacheEntry(byPkg[p], genByPkg[p])); err != nil {
+			log.Printf("[CACHE] %s: %v", p.ID, err)
+		}
+	}
+	return results, gen, skipped, nil
+}
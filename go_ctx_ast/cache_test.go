@@ -0,0 +1,75 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestCacheKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+		return filename
+	}
+	export := write("fmt.a", "export data v1")
+	fmtPkg := &packages.Package{ID: "fmt", PkgPath: "fmt", ExportFile: export}
+	store := &packages.Package{ID: "example.com/store", PkgPath: "example.com/store", GoFiles: []string{write("store.go", "package store")}}
+	api := &packages.Package{ID: "example.com/api", PkgPath: "example.com/api", GoFiles: []string{write("api.go", "package api")},
+		Imports: map[string]*packages.Package{"fmt": fmtPkg, "example.com/store": store}}
+	roots := []*packages.Package{api, store}
+
+	keys := func(salt string) map[string]string {
+		c := &analysisCache{dir: dir, salt: salt, exports: map[string]string{}}
+		return c.keys(roots)
+	}
+	before := keys("v1")
+	assert.Len(t, before, 2)
+	assert.NotEqual(t, before, keys("v2"), "the tool and its flags are part of the key")
+
+	// a change to a package of the run changes the key of its importers
+	write("store.go", "package store // changed")
+	after := keys("v1")
+	assert.NotEqual(t, before["example.com/store"], after["example.com/store"])
+	assert.NotEqual(t, before["example.com/api"], after["example.com/api"])
+
+	// so does a change to the export data of a dependency, for its importers only
+	before = after
+	write("fmt.a", "export data v2")
+	after = keys("v1")
+	assert.Equal(t, before["example.com/store"], after["example.com/store"])
+	assert.NotEqual(t, before["example.com/api"], after["example.com/api"])
+
+	// packages with errors are never cached
+	store.Errors = []packages.Error{{Msg: "broken"}}
+	assert.Empty(t, keys("v1"))
+}
+
+func TestCacheEntry(t *testing.T) {
+	c := &analysisCache{dir: t.TempDir()}
+	results := []fileResult{{
+		filename: "/src/svc/svc.go",
+		findings: []finding{{
+			pos:  token.Position{Filename: "/src/svc/svc.go", Offset: 40, Line: 5, Column: 5},
+			text: "r.Context()", edit: edit{start: 40, end: 54, text: "r.Context()"},
+			fn: "svc.Handle", alts: []string{"context.WithoutCancel(r.Context())"},
+		}},
+		unresolved: []unresolvedSite{{pos: token.Position{Filename: "/src/svc/svc.go", Offset: 80, Line: 9, Column: 5}, fn: "svc.Run", reason: reasonSuppressed, detail: "startup"}},
+	}, {filename: "/src/svc/util.go"}}
+	gen := generatedStats{"/src/svc/svc.pb.go": {generator: "protoc-gen-go", todos: 2}}
+
+	assert.NoError(t, c.put("k", newCacheEntry(results, gen)))
+	e, ok := c.get("k")
+	assert.True(t, ok)
+	gotResults, gotGen := e.results()
+	assert.Equal(t, results, gotResults)
+	assert.Equal(t, gen, gotGen)
+
+	_, ok = c.get("missing")
+	assert.False(t, ok)
+}
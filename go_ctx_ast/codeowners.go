@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// codeOwnersLocations are the places GitHub looks for a CODEOWNERS file, in order.
+var codeOwnersLocations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// ownerRule is one CODEOWNERS line.
+type ownerRule struct {
+	line    int
+	pattern string
+	owners  []string
+
+	elems   []string // pattern elements, "**" included
+	dirOnly bool     // trailing slash: only matches the contents of a directory
+}
+
+// codeOwners is a parsed CODEOWNERS file.
+type codeOwners struct {
+	rules []ownerRule
+}
+
+// findCodeOwners returns the path of the CODEOWNERS file under root that GitHub
+// would use.
+func findCodeOwners(root string) (string, error) {
+	for _, loc := range codeOwnersLocations {
+		p := filepath.Join(root, filepath.FromSlash(loc))
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no CODEOWNERS file in %s", strings.Join(codeOwnersLocations, ", "))
+}
+
+// loadCodeOwners parses the CODEOWNERS file at path, or the one GitHub would use
+// under root if path is empty, and logs the lines it ignores.
+func loadCodeOwners(root, path string) (*codeOwners, error) {
+	if path == "" {
+		var err error
+		if path, err = findCodeOwners(root); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	co, warnings, err := parseCodeOwners(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, w := range warnings {
+		log.Printf("[WARN] %s: %s", path, w)
+	}
+	return co, nil
+}
+
+// parseCodeOwners parses r with GitHub's rules: `#` starts a comment, the first
+// field is a pattern and the rest are owners (a pattern with no owners leaves its
+// files unowned). Lines GitHub would reject, such as `!` negations, which it does
+// not support, are returned as warnings and ignored.
+func parseCodeOwners(r io.Reader) (*codeOwners, []string, error) {
+	co := &codeOwners{}
+	var warnings []string
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pattern := fields[0]
+		if strings.HasPrefix(pattern, "!") {
+			warnings = append(warnings, fmt.Sprintf("line %d: negated pattern %s is not supported by GitHub; ignored", n, pattern))
+			continue
+		}
+		if strings.ContainsAny(pattern, "[]") {
+			warnings = append(warnings, fmt.Sprintf("line %d: character ranges in %s are not supported by GitHub; ignored", n, pattern))
+			continue
+		}
+		co.rules = append(co.rules, newOwnerRule(n, pattern, fields[1:]))
+	}
+	return co, warnings, sc.Err()
+}
+
+// newOwnerRule compiles pattern the way gitignore does: a leading or inner slash
+// anchors it at the repository root, otherwise it matches at any depth.
+func newOwnerRule(line int, pattern string, owners []string) ownerRule {
+	r := ownerRule{line: line, pattern: pattern, owners: owners}
+	p := pattern
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	r.elems = strings.Split(p, "/")
+	if !anchored && r.elems[0] != "**" {
+		r.elems = append([]string{"**"}, r.elems...)
+	}
+	return r
+}
+
+// match reports whether the slash-separated, root-relative file name matches r.
+// A pattern that matches a directory also matches everything below it, except that
+// a final "/*" only matches the directory's direct children (docs/* does not own
+// docs/build/app.md).
+func (r ownerRule) match(name string) bool {
+	elems := strings.Split(name, "/")
+	childrenOnly := len(r.elems) > 1 && r.elems[len(r.elems)-1] == "*"
+	for k := 1; k <= len(elems); k++ {
+		isFile := k == len(elems)
+		if (isFile && r.dirOnly) || (!isFile && childrenOnly) {
+			continue
+		}
+		if matchElems(r.elems, elems[:k]) {
+			return true
+		}
+	}
+	return false
+}
+
+// owners returns the owners of name and the rule that assigned them; the last
+// matching rule wins. ok is false if no rule matches.
+func (co *codeOwners) owners(name string) (owners []string, rule ownerRule, ok bool) {
+	for i := len(co.rules) - 1; i >= 0; i-- {
+		if co.rules[i].match(name) {
+			return co.rules[i].owners, co.rules[i], true
+		}
+	}
+	return nil, ownerRule{}, false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeOwners(t *testing.T) {
+	co, warnings, err := parseCodeOwners(strings.NewReader(`# default owners
+*                   @org/platform
+
+*.md                @org/docs # trailing comment
+/build/logs/        @org/infra
+apps/               @org/apps
+docs/*              docs@example.com
+**/generated        @org/codegen
+/services/**/api    @org/api @alice @org/api
+/services/legacy
+!/services/keep     @org/keep
+/[ab]/              @org/range
+`))
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 2)
+
+	cases := []struct {
+		name   string
+		owners []string
+	}{
+		{"main.go", []string{"@org/platform"}},
+		{"README.md", []string{"@org/docs"}},
+		{"pkg/notes/README.md", []string{"@org/docs"}},
+		{"build/logs/x.go", []string{"@org/infra"}},
+		{"sub/build/logs/x.go", []string{"@org/platform"}}, // anchored
+		{"build/logs", []string{"@org/platform"}},          // a file, not the directory
+		{"apps/a.go", []string{"@org/apps"}},
+		{"x/apps/deep/a.go", []string{"@org/apps"}}, // unanchored directory
+		{"docs/intro.go", []string{"docs@example.com"}},
+		{"docs/build/app.go", []string{"@org/platform"}}, // docs/* is not recursive
+		{"a/generated/b/c.go", []string{"@org/codegen"}},
+		{"generated/c.go", []string{"@org/codegen"}},
+		{"services/api/h.go", []string{"@org/api", "@alice", "@org/api"}},
+		{"services/x/y/api/h.go", []string{"@org/api", "@alice", "@org/api"}},
+		{"services/legacy/old.go", []string{}},            // no owners, last match wins
+		{"services/keep/k.go", []string{"@org/platform"}}, // negation ignored
+	}
+	for _, tc := range cases {
+		owners, _, ok := co.owners(tc.name)
+		assert.True(t, ok, tc.name)
+		assert.Equal(t, tc.owners, owners, tc.name)
+	}
+
+	none, _, _ := parseCodeOwners(strings.NewReader("/only/ @o\n"))
+	_, _, ok := none.owners("elsewhere.go")
+	assert.False(t, ok)
+}
+
+func TestGroupByOwners(t *testing.T) {
+	co, _, err := parseCodeOwners(strings.NewReader(`/a/ @team-b @team-a
+/b/ @team-a @team-b
+/c/
+/d/ @team-c
+`))
+	assert.NoError(t, err)
+	groups := groupByOwners(co, []string{"d/1.go", "c/1.go", "b/1.go", "a/1.go", "e/1.go"})
+	assert.Equal(t, []ownerGroup{
+		{owners: []string{"@team-a", "@team-b"}, files: []string{"a/1.go", "b/1.go"}},
+		{owners: []string{"@team-c"}, files: []string{"d/1.go"}},
+		{owners: nil, files: []string{"c/1.go", "e/1.go"}},
+	}, groups)
+
+	assert.Equal(t, "ctx/team-a+team-b", groups[0].branchName("ctx/", 0))
+	assert.Equal(t, "ctx/unowned-2", groups[2].branchName("ctx", 2))
+	assert.Equal(t, "ctx/org-team+a-example.com", ownerGroup{owners: []string{"@org/team", "a@example.com"}}.branchName("ctx", 0))
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ctxParams implements -lint-ctx-params. It reports the context parameters that
+// mass contexification leaves behind: ones an unexported function never uses, and
+// ones that are not the first parameter, which the context package asks for: "The
+// Context should be the first parameter, typically named ctx".
+//
+// With -fix, unused parameters are removed and misplaced ones moved to the front,
+// in the declaration and in every call.
+type ctxParams struct {
+	editSet
+
+	funcs        map[funcKey]*ctxParamFunc // reported functions
+	ifaceMethods map[string]bool           // names of interface methods, declared or imported
+	params       map[token.Position]bool   // parameter declarations
+	src          map[string][]byte         // file contents, by filename
+
+	reports []finding
+}
+
+// ctxParamFunc is a function whose context parameter is unused or misplaced.
+type ctxParamFunc struct {
+	unit     migrationUnit
+	decl     *ast.FuncDecl
+	param    declaredParam
+	index    int
+	unused   bool // remove the parameter rather than move it
+	calls    []ctxParamCall
+	excluded string // why the fix leaves the function alone
+}
+
+// ctxParamCall is a call of a ctxParamFunc.
+type ctxParamCall struct {
+	unit migrationUnit
+	call *ast.CallExpr
+}
+
+// runCtxParamLint implements -lint-ctx-params and returns the exit status: 1 if
+// any context parameter is reported and -fix was not given.
+func runCtxParamLint(configs []buildConfig, patterns []string, changed changedLines) int {
+	pkgs, status := loadWholeProgram("-lint-ctx-params", configs, patterns, flagFix)
+	if pkgs == nil {
+		return status
+	}
+	reports, results := lintContextParams(pkgs, flagFix)
+	if changed != nil {
+		reports = changed.filter(reports)
+	}
+	for _, r := range reports {
+		fmt.Printf("[LINT] %s:%d: %s\n", r.pos.Filename, r.pos.Line, r.text)
+	}
+	if !flagFix {
+		if len(reports) > 0 {
+			return 1
+		}
+		return 0
+	}
+	return applyResults(results)
+}
+
+// lintContextParams reports the unused and misplaced context parameters of the
+// functions in pkgs, which must all come from one loadPackages call, and with fix
+// computes the edits of the fix.
+func lintContextParams(pkgs []*packages.Package, fix bool) ([]finding, []fileResult) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	m := &ctxParams{
+		editSet:      newEditSet(pkgs[0].Fset),
+		funcs:        map[funcKey]*ctxParamFunc{},
+		ifaceMethods: importedInterfaceMethods(pkgs),
+		params:       map[token.Position]bool{},
+		src:          map[string][]byte{},
+	}
+	units := migrationUnits(pkgs)
+	for _, u := range units {
+		m.collect(u)
+	}
+	sort.Slice(m.reports, func(i, j int) bool { return positionLess(m.reports[i].pos, m.reports[j].pos) })
+	if !fix {
+		return m.reports, nil
+	}
+
+	for _, u := range units {
+		m.collectCalls(u)
+	}
+	for _, f := range m.funcs {
+		m.exclude(f)
+	}
+	m.excludeUnusedLeftovers()
+	m.reportExcluded()
+
+	for _, f := range m.funcs {
+		if f.excluded == "" {
+			m.rewrite(f)
+		}
+	}
+	m.removeImports(units)
+	return m.reports, m.results()
+}
+
+// collect records the interface methods, parameters and receivers declared in u
+// and reports its functions' context parameters.
+func (m *ctxParams) collect(u migrationUnit) {
+	info := u.pkg.TypesInfo
+	ast.Inspect(u.file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.InterfaceType:
+			for _, meth := range node.Methods.List {
+				for _, nm := range meth.Names {
+					m.ifaceMethods[nm.Name] = true
+				}
+			}
+		case *ast.FuncType:
+			if node.Params != nil {
+				for _, fld := range node.Params.List {
+					for _, nm := range fld.Names {
+						m.params[m.fset.Position(nm.Pos())] = true
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if node.Recv != nil {
+				for _, fld := range node.Recv.List {
+					for _, nm := range fld.Names {
+						m.params[m.fset.Position(nm.Pos())] = true
+					}
+				}
+			}
+			m.check(u, info, node)
+		}
+		return true
+	})
+}
+
+// check reports fd if its first context parameter is unused and fd is unexported,
+// or if it is not the first parameter.
+func (m *ctxParams) check(u migrationUnit, info *types.Info, fd *ast.FuncDecl) {
+	for i, p := range declaredParams(info, fd.Type) {
+		if p.typ == nil || !isContextValue(p.typ) {
+			continue
+		}
+		f := &ctxParamFunc{unit: u, decl: fd, param: p, index: i}
+		obj := info.Defs[p.name]
+		if !fd.Name.IsExported() && p.name.Name != "_" && obj != nil && fd.Body != nil {
+			f.unused = true
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && info.Uses[id] == obj {
+					f.unused = false
+				}
+				return f.unused
+			})
+		}
+		pos := m.fset.Position(p.name.Pos())
+		switch {
+		case f.unused:
+			m.reports = append(m.reports, finding{pos: pos, text: fmt.Sprintf("%s never uses its context parameter %s; remove it", fd.Name.Name, p.name.Name)})
+		case i > 0:
+			m.reports = append(m.reports, finding{pos: pos, text: fmt.Sprintf("%s takes its context as parameter %d; make it the first", fd.Name.Name, i+1)})
+		default:
+			return
+		}
+		pos = m.fset.Position(fd.Name.Pos())
+		m.funcs[funcKey{pos.Filename, pos.Line, fd.Name.Name}] = f
+		return
+	}
+}
+
+// lookup returns the reported function fn, or nil.
+func (m *ctxParams) lookup(fn *types.Func) *ctxParamFunc {
+	return m.funcs[declKey(m.fset, fn)]
+}
+
+// collectCalls records the calls of reported functions in u and excludes the
+// functions referenced other than by a direct call: their signature may have to
+// match a function type elsewhere.
+func (m *ctxParams) collectCalls(u migrationUnit) {
+	info := u.pkg.TypesInfo
+	called := map[*ast.Ident]bool{}
+	ast.Inspect(u.file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		id := calleeIdent(call)
+		if id == nil {
+			return true
+		}
+		if sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr); ok && info.Types[sel.X].IsType() {
+			return true // a method expression
+		}
+		fn, ok := info.Uses[id].(*types.Func)
+		if !ok {
+			return true
+		}
+		if f := m.lookup(fn); f != nil {
+			called[id] = true
+			f.calls = append(f.calls, ctxParamCall{u, call})
+		}
+		return true
+	})
+	ast.Inspect(u.file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || called[id] {
+			return true
+		}
+		if fn, ok := info.Uses[id].(*types.Func); ok {
+			if f := m.lookup(fn); f != nil && f.excluded == "" {
+				p := m.fset.Position(id.Pos())
+				f.excluded = fmt.Sprintf("it is used as a function value at %s:%d", p.Filename, p.Line)
+			}
+		}
+		return true
+	})
+}
+
+// exclude decides whether the fix can change f and all its calls.
+func (m *ctxParams) exclude(f *ctxParamFunc) {
+	fd := f.decl
+	switch {
+	case f.excluded != "":
+		return
+	case fd.Body == nil:
+		f.excluded = "it has no body"
+		return
+	case fd.Recv != nil && m.ifaceMethods[fd.Name.Name]:
+		f.excluded = "method " + fd.Name.Name + " may implement an interface"
+		return
+	}
+	for _, c := range f.unit.file.Comments {
+		if c.Pos() > fd.Type.Params.Opening && c.End() < fd.Type.Params.Closing {
+			f.excluded = "its parameter list has comments"
+			return
+		}
+	}
+	sig := f.unit.pkg.TypesInfo.Defs[fd.Name].Type().(*types.Signature)
+	for _, c := range f.calls {
+		p := m.fset.Position(c.call.Pos())
+		n := len(c.call.Args)
+		if n <= f.index || n < sig.Params().Len()-1 || (!sig.Variadic() && n != sig.Params().Len()) {
+			f.excluded = fmt.Sprintf("the call at %s:%d passes a multi-value expression", p.Filename, p.Line)
+			return
+		}
+		if f.unused && !sideEffectFree(c.call.Args[f.index]) {
+			f.excluded = fmt.Sprintf("the call at %s:%d passes %s, which may have side effects", p.Filename, p.Line, types.ExprString(c.call.Args[f.index]))
+			return
+		}
+		// moving the context first would evaluate it before the arguments ahead of it
+		for _, arg := range c.call.Args[:f.index] {
+			if !f.unused && !sideEffectFree(arg) {
+				f.excluded = fmt.Sprintf("the call at %s:%d passes %s before the context, which may have side effects", p.Filename, p.Line, types.ExprString(arg))
+				return
+			}
+		}
+	}
+}
+
+// excludeUnusedLeftovers excludes the removals that would delete the last use of a
+// local variable, which then fails to compile.
+func (m *ctxParams) excludeUnusedLeftovers() {
+	removed := map[string][]edit{}
+	for _, f := range m.funcs {
+		if f.excluded != "" || !f.unused {
+			continue
+		}
+		for _, c := range f.calls {
+			arg := c.call.Args[f.index]
+			filename := m.fset.Position(arg.Pos()).Filename
+			removed[filename] = append(removed[filename], edit{start: m.offset(arg.Pos()), end: m.offset(arg.End())})
+		}
+	}
+	deleted := func(p token.Position) bool {
+		for _, e := range removed[p.Filename] {
+			if p.Offset >= e.start && p.Offset < e.end {
+				return true
+			}
+		}
+		return false
+	}
+	for _, f := range m.funcs {
+		if f.excluded != "" || !f.unused {
+			continue
+		}
+		for _, c := range f.calls {
+			info := c.unit.pkg.TypesInfo
+			ast.Inspect(c.call.Args[f.index], func(n ast.Node) bool {
+				id, ok := n.(*ast.Ident)
+				if !ok || f.excluded != "" {
+					return f.excluded == ""
+				}
+				obj, ok := info.Uses[id].(*types.Var)
+				if !ok || obj.IsField() || obj.Parent() == nil || obj.Parent() == obj.Pkg().Scope() || m.params[m.fset.Position(obj.Pos())] {
+					return true
+				}
+				for use, o := range info.Uses {
+					if o == obj && !deleted(m.fset.Position(use.Pos())) {
+						return true
+					}
+				}
+				f.excluded = "it would leave " + obj.Name() + " unused"
+				return false
+			})
+		}
+	}
+}
+
+func (m *ctxParams) reportExcluded() {
+	var skipped []funcKey
+	for key, f := range m.funcs {
+		if f.excluded != "" {
+			skipped = append(skipped, key)
+		}
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].less(skipped[j]) })
+	for _, key := range skipped {
+		log.Printf("[SKIP] %s:%d: %s not fixed: %s", key.filename, key.line, key.name, m.funcs[key].excluded)
+	}
+}
+
+// source returns the content of filename.
+func (m *ctxParams) source(filename string) []byte {
+	if src, ok := m.src[filename]; ok {
+		return src
+	}
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		log.Printf("read %s: %v", filename, err)
+	}
+	m.src[filename] = src
+	return src
+}
+
+// deleteListItem returns the edit deleting item(i) of n comma-separated items,
+// with one of the separators around it.
+func (m *ctxParams) deleteListItem(i, n int, item func(int) ast.Node) edit {
+	switch {
+	case i > 0:
+		return edit{start: m.offset(item(i - 1).End()), end: m.offset(item(i).End())}
+	case n > 1:
+		return edit{start: m.offset(item(0).Pos()), end: m.offset(item(1).Pos())}
+	}
+	return edit{start: m.offset(item(0).Pos()), end: m.offset(item(0).End())}
+}
+
+// text returns the source of n.
+func (m *ctxParams) text(n ast.Node) string {
+	return string(m.source(m.fset.Position(n.Pos()).Filename)[m.offset(n.Pos()):m.offset(n.End())])
+}
+
+// rewrite removes or moves the context parameter of f and the matching argument
+// of its calls. The rest of the parameter list keeps its layout.
+func (m *ctxParams) rewrite(f *ctxParamFunc) {
+	fd, name := f.decl, f.param.name.Name
+	fields := fd.Type.Params.List
+	var del edit
+	if len(f.param.field.Names) == 1 {
+		i := slices.Index(fields, f.param.field)
+		del = m.deleteListItem(i, len(fields), func(j int) ast.Node { return fields[j] })
+	} else {
+		names := f.param.field.Names
+		i := slices.Index(names, f.param.name)
+		del = m.deleteListItem(i, len(names), func(j int) ast.Node { return names[j] })
+	}
+	if f.unused {
+		m.note(fd.Name.Pos(), fmt.Sprintf("%s: removed parameter %s", fd.Name.Name, name), del)
+	} else {
+		first := fields[0].Pos()
+		param := name + " " + m.text(f.param.field.Type) + ", "
+		if m.fset.Position(first).Line != m.fset.Position(fd.Type.Params.Opening).Line {
+			// one parameter per line
+			indent, _ := lineIndent(m.source(m.fset.Position(first).Filename), m.offset(first))
+			param = strings.TrimSuffix(param, " ") + "\n" + indent
+		}
+		at := m.offset(first)
+		m.note(fd.Name.Pos(), fmt.Sprintf("%s: moved parameter %s first", fd.Name.Name, name), edit{start: at, end: at, text: param})
+		m.note(fd.Name.Pos(), "", del)
+	}
+	if f.unused && len(f.param.field.Names) == 1 {
+		// the import is no longer needed for the parameter's type
+		filename := m.fset.Position(fd.Pos()).Filename
+		typ := f.param.field.Type
+		m.removed[filename] = append(m.removed[filename], edit{start: m.offset(typ.Pos()), end: m.offset(typ.End())})
+	}
+
+	for _, c := range f.calls {
+		args := c.call.Args
+		arg := args[f.index]
+		del := m.deleteListItem(f.index, len(args), func(j int) ast.Node { return args[j] })
+		call := types.ExprString(c.call.Fun)
+		if f.unused {
+			m.note(c.call.Pos(), fmt.Sprintf("%s(...) → drops %s", call, types.ExprString(arg)), del)
+			filename := m.fset.Position(arg.Pos()).Filename
+			m.removed[filename] = append(m.removed[filename], del)
+			continue
+		}
+		at := m.offset(args[0].Pos())
+		m.note(c.call.Pos(), fmt.Sprintf("%s(...) → passes %s first", call, types.ExprString(arg)), edit{start: at, end: at, text: m.text(arg) + ", "})
+		m.note(c.call.Pos(), "", del)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintContextParams(t *testing.T) {
+	dir := t.TempDir()
+	storeFile := filepath.Join(dir, "store.go")
+	runFile := filepath.Join(dir, "run.go")
+	apiFile := filepath.Join(dir, "api.go")
+	testFile := filepath.Join(dir, "store_test.go")
+	srcs := map[string]string{
+		storeFile: `package store
+
+import "context"
+
+func Get(id int, ctx context.Context) string {
+	return load(ctx, id) + save(newCtx(), "x")
+}
+
+func load(ctx context.Context, id int) string {
+	return "a"
+}
+
+func save(ctx context.Context, v string) string {
+	return v
+}
+
+func notify(ctx context.Context) {}
+
+var hook = notify
+
+func newCtx() context.Context { return context.Background() }
+`,
+		runFile: `package store
+
+import (
+	"context"
+	"fmt"
+)
+
+func run() {
+	fmt.Println(load(context.TODO(), 3))
+}
+`,
+		apiFile: `package api
+
+import (
+	"context"
+
+	"example.com/store"
+)
+
+func Handle(ctx context.Context) string {
+	return store.Get(1, ctx)
+}
+`,
+		testFile: `package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	if load(ctx, 1) != "a" || Get(2, ctx) == "" {
+		t.Fail()
+	}
+}
+`,
+	}
+	for filename, src := range srcs {
+		assert.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+	}
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/store", "example.com/api"}, map[string]map[string]string{
+		"example.com/store": {storeFile: srcs[storeFile], runFile: srcs[runFile]},
+		"example.com/api":   {apiFile: srcs[apiFile]},
+	})
+	// the test variant of store, as a load with tests returns it next to store
+	variant := testPackages(t, fset, []string{"example.com/store"}, map[string]map[string]string{
+		"example.com/store": {storeFile: srcs[storeFile], runFile: srcs[runFile], testFile: srcs[testFile]},
+	})[0]
+	variant.ID = "example.com/store [example.com/store.test]"
+	pkgs = append(pkgs, variant)
+
+	reports, _ := lintContextParams(pkgs, false)
+	var texts []string
+	for _, r := range reports {
+		texts = append(texts, r.text)
+	}
+	assert.Equal(t, []string{
+		"Get takes its context as parameter 2; make it the first",
+		"load never uses its context parameter ctx; remove it",
+		"save never uses its context parameter ctx; remove it",
+		"notify never uses its context parameter ctx; remove it",
+	}, texts)
+
+	_, results := lintContextParams(pkgs, true)
+	out := map[string]string{}
+	for _, res := range results {
+		var edits []edit
+		for _, f := range res.findings {
+			edits = append(edits, f.edit)
+		}
+		b, err := applyEdits([]byte(srcs[res.filename]), edits)
+		assert.NoError(t, err)
+		out[res.filename] = string(b)
+	}
+	// save is called with an expression that may have side effects and notify is
+	// used as a function value: both are left alone
+	assert.Equal(t, `package store
+
+import "context"
+
+func Get(ctx context.Context, id int) string {
+	return load(id) + save(newCtx(), "x")
+}
+
+func load(id int) string {
+	return "a"
+}
+
+func save(ctx context.Context, v string) string {
+	return v
+}
+
+func notify(ctx context.Context) {}
+
+var hook = notify
+
+func newCtx() context.Context { return context.Background() }
+`, out[storeFile])
+	assert.Equal(t, `package store
+
+import (
+	"fmt"
+)
+
+func run() {
+	fmt.Println(load(3))
+}
+`, out[runFile])
+	assert.Contains(t, out[apiFile], "return store.Get(ctx, 1)")
+	assert.Contains(t, out[testFile], `if load(1) != "a" || Get(ctx, 2) == "" {`)
+}
+
+func TestLintContextParamsLayout(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "store.go")
+	src := `package store
+
+import "context"
+
+func Put(
+	key string,
+	ctx context.Context,
+	value string,
+) error {
+	return Use(ctx, key+value)
+}
+
+func drop(
+	key string,
+	ctx context.Context,
+) {
+	_ = key
+}
+
+func Move(id int, ctx context.Context) error {
+	return Use(ctx, "")
+}
+
+func caller(ctx context.Context) {
+	Put("k", ctx, "v")
+	drop("k", ctx)
+	Move(next(), ctx)
+}
+
+func next() int { return 1 }
+
+func Use(ctx context.Context, s string) error { return nil }
+`
+	assert.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/store"}, map[string]map[string]string{
+		"example.com/store": {filename: src},
+	})
+	_, results := lintContextParams(pkgs, true)
+	if !assert.Len(t, results, 1) {
+		return
+	}
+	var edits []edit
+	for _, f := range results[0].findings {
+		edits = append(edits, f.edit)
+	}
+	out, err := applyEdits([]byte(src), edits)
+	assert.NoError(t, err)
+	// Move's call evaluates next() before the context, so it stays
+	assert.Equal(t, `package store
+
+import "context"
+
+func Put(
+	ctx context.Context,
+	key string,
+	value string,
+) error {
+	return Use(ctx, key+value)
+}
+
+func drop(
+	key string,
+) {
+	_ = key
+}
+
+func Move(id int, ctx context.Context) error {
+	return Use(ctx, "")
+}
+
+func caller(ctx context.Context) {
+	Put(ctx, "k", "v")
+	drop("k")
+	Move(next(), ctx)
+}
+
+func next() int { return 1 }
+
+func Use(ctx context.Context, s string) error { return nil }
+`, string(out))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lineRange is an inclusive range of line numbers.
+type lineRange struct {
+	first, last int
+}
+
+// changedLines maps absolute file names to the lines a diff adds or changes (-since).
+type changedLines map[string][]lineRange
+
+// gitChangedLines returns the lines that differ from rev, as `git diff rev` reports
+// them: a single revision compares the working tree against it, a range such as
+// main...HEAD compares the two commits. Untracked files are not part of the diff.
+func gitChangedLines(rev string) (changedLines, error) {
+	top, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	// the prefixes and paths parseDiff expects, whatever diff.noprefix,
+	// diff.mnemonicPrefix or diff.relative say
+	out, err := gitOutput("-c", "core.quotePath=false", "diff", "--unified=0", "--no-color", "--no-ext-diff",
+		"--src-prefix=a/", "--dst-prefix=b/", "--no-relative", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	return parseDiff(bytes.NewReader(out), strings.TrimSpace(string(top)))
+}
+
+// repoRoot returns the top directory of the git repository containing the working
+// directory, or the working directory itself outside of one, with symlinks resolved
+// as git resolves them.
+func repoRoot() (string, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if out, err := gitOutput("rev-parse", "--show-toplevel"); err == nil {
+		root = strings.TrimSpace(string(out))
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	return root, nil
+}
+
+// realPath resolves the symlinks in filename, if it can.
+func realPath(filename string) string {
+	if real, err := filepath.EvalSymlinks(filename); err == nil {
+		return real
+	}
+	return filename
+}
+
+// gitOutput runs git with args in the current directory and returns its stdout.
+func gitOutput(args ...string) ([]byte, error) {
+	return gitRun("", nil, nil, args...)
+}
+
+// gitRun runs git with args in dir, with env added to the environment and stdin
+// as its input, and returns its stdout. Errors include what git printed.
+func gitRun(dir string, env []string, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = stdin
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(exit.Stderr))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// hunkHeader matches "@@ -a[,b] +c[,d] @@"; c and d describe the new side.
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// parseDiff reads a unified diff with zero context lines and returns the new-side
+// line ranges of every hunk, keyed by file name joined to root. Pure deletions add
+// no lines and are ignored.
+func parseDiff(r io.Reader, root string) (changedLines, error) {
+	changed := changedLines{}
+	file := ""
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if name, ok := strings.CutPrefix(line, "+++ "); ok {
+			file = ""
+			if name == "/dev/null" {
+				continue // deleted file
+			}
+			if strings.HasPrefix(name, `"`) {
+				unq, err := strconv.Unquote(name)
+				if err != nil {
+					return nil, fmt.Errorf("diff: bad file name %s", name)
+				}
+				name = unq
+			}
+			file = filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(name, "b/")))
+			continue
+		}
+		m := hunkHeader.FindStringSubmatch(line)
+		if m == nil || file == "" {
+			continue
+		}
+		first, _ := strconv.Atoi(m[1])
+		count := 1
+		if m[2] != "" {
+			count, _ = strconv.Atoi(m[2])
+		}
+		if count > 0 {
+			changed[file] = append(changed[file], lineRange{first, first + count - 1})
+		}
+	}
+	return changed, sc.Err()
+}
+
+// contains reports whether line of filename was added or changed.
+func (c changedLines) contains(filename string, line int) bool {
+	ranges, ok := c[filename]
+	if !ok {
+		// git reports the real path; the packages may have been loaded through a symlink
+		ranges = c[realPath(filename)]
+	}
+	for _, r := range ranges {
+		if line >= r.first && line <= r.last {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the findings on changed lines.
+func (c changedLines) filter(fs []finding) []finding {
+	var kept []finding
+	for _, f := range fs {
+		if c.contains(f.pos.Filename, f.pos.Line) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
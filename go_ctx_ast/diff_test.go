@@ -0,0 +1,90 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiff(t *testing.T) {
+	diff := `diff --git a/svc/a.go b/svc/a.go
+index 1111111..2222222 100644
+--- a/svc/a.go
++++ b/svc/a.go
+@@ -3,0 +4,2 @@ import "context"
++func f() {}
++
+@@ -10 +12 @@ func g() {
+-	x := 1
++	x := 2
+@@ -20,3 +21,0 @@ func h() {
+-	a
+-	b
+-	c
+diff --git a/gone.go b/gone.go
+deleted file mode 100644
+--- a/gone.go
++++ /dev/null
+@@ -1,2 +0,0 @@
+-package gone
+-
+diff --git "a/we\"ird.go" "b/we\"ird.go"
+--- "a/we\"ird.go"
++++ "b/we\"ird.go"
+@@ -1 +1 @@
+-package a
++package b
+`
+	changed, err := parseDiff(strings.NewReader(diff), "/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, changedLines{
+		filepath.FromSlash("/repo/svc/a.go"):  {{4, 5}, {12, 12}},
+		filepath.FromSlash(`/repo/we"ird.go`): {{1, 1}},
+	}, changed)
+
+	a := filepath.FromSlash("/repo/svc/a.go")
+	assert.True(t, changed.contains(a, 5))
+	assert.True(t, changed.contains(a, 12))
+	assert.False(t, changed.contains(a, 6))
+	assert.False(t, changed.contains(a, 21), "deleted lines leave nothing to rewrite")
+	assert.False(t, changed.contains(filepath.FromSlash("/repo/other.go"), 1))
+
+	kept := changed.filter([]finding{
+		{pos: token.Position{Filename: a, Line: 4}, text: "ctx"},
+		{pos: token.Position{Filename: a, Line: 7}, text: "ctx"},
+	})
+	assert.Equal(t, []finding{{pos: token.Position{Filename: a, Line: 4}, text: "ctx"}}, kept)
+}
+
+func TestGitChangedLines(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	git := func(args ...string) {
+		_, err := gitRun(dir, nil, nil, args...)
+		assert.NoError(t, err)
+	}
+	filename := filepath.Join(dir, "svc", "a.go")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+	assert.NoError(t, os.WriteFile(filename, []byte("a\nb\nc\n"), 0o644))
+	git("init", "-q")
+	git("config", "diff.noprefix", "true")
+	git("config", "diff.relative", "true")
+	git("add", "-A")
+	git("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "base")
+	assert.NoError(t, os.WriteFile(filename, []byte("a\nB\nc\nd\n"), 0o644))
+
+	t.Chdir(filepath.Dir(filename))
+	changed, err := gitChangedLines("HEAD")
+	assert.NoError(t, err)
+	assert.Equal(t, changedLines{filename: {{2, 2}, {4, 4}}}, changed)
+}
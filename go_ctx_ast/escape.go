@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"go/version"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// reasonEscapes leaves a call in place when its context would outlive the call and
+// context.WithoutCancel is not available to detach it.
+const reasonEscapes = "context outlives the call"
+
+// asyncCallbacks are functions that run a func argument after they return.
+var asyncCallbacks = []struct{ pkgPath, name string }{
+	{"time", "AfterFunc"},
+}
+
+// funcKey identifies a function declaration, or another object's, by name and
+// position, which stay the same whether its package was loaded from source or from
+// export data.
+type funcKey struct {
+	filename string
+	line     int
+	name     string
+}
+
+// declKey returns the funcKey of fn, so that a function matches its uses in
+// packages loaded separately, like those of another module (see loadPackages).
+func declKey(fset *token.FileSet, fn *types.Func) funcKey {
+	p := fset.Position(fn.Origin().Pos())
+	return funcKey{p.Filename, p.Line, fn.Name()}
+}
+
+func (k funcKey) less(o funcKey) bool {
+	if k.filename != o.filename {
+		return k.filename < o.filename
+	}
+	if k.line != o.line {
+		return k.line < o.line
+	}
+	return k.name < o.name
+}
+
+// funcDecl is a function declaration with the type information of its package.
+type funcDecl struct {
+	decl *ast.FuncDecl
+	pkg  *packages.Package
+}
+
+// funcIndex holds the function declarations of the loaded packages, for looking
+// into callees.
+type funcIndex map[funcKey]funcDecl
+
+func newFuncIndex(pkgs []*packages.Package) funcIndex {
+	idx := funcIndex{}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			for _, d := range file.Decls {
+				if fd, ok := d.(*ast.FuncDecl); ok && fd.Body != nil {
+					idx[idx.key(pkg.Fset, fd.Name.Pos(), fd.Name.Name)] = funcDecl{fd, pkg}
+				}
+			}
+		}
+	}
+	return idx
+}
+
+func (idx funcIndex) key(fset *token.FileSet, pos token.Pos, name string) funcKey {
+	p := fset.Position(pos)
+	return funcKey{p.Filename, p.Line, name}
+}
+
+// withoutCancelAvailable reports whether file may call context.WithoutCancel (Go 1.21).
+func withoutCancelAvailable(info *types.Info, file *ast.File) bool {
+	v := info.FileVersions[file]
+	return v == "" || version.Compare(v, "go1.21") >= 0
+}
+
+// argCalls maps every call expression that is an argument of another call to that
+// call and the argument's index.
+func argCalls(file *ast.File) map[*ast.CallExpr]argOf {
+	args := map[*ast.CallExpr]argOf{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			for i, arg := range call.Args {
+				if c, ok := ast.Unparen(arg).(*ast.CallExpr); ok {
+					args[c] = argOf{call, i}
+				}
+			}
+		}
+		return true
+	})
+	return args
+}
+
+type argOf struct {
+	call  *ast.CallExpr
+	index int
+}
+
+// calledFunc returns the function or method call invokes statically, if any.
+func calledFunc(info *types.Info, call *ast.CallExpr) *types.Func {
+	var id *ast.Ident
+	switch fun := ast.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	default:
+		return nil
+	}
+	fn, _ := info.Uses[id].(*types.Func)
+	if fn == nil {
+		return nil
+	}
+	if sig, ok := fn.Type().(*types.Signature); ok && sig.Recv() != nil && types.IsInterface(sig.Recv().Type()) {
+		return nil // dynamic call
+	}
+	return fn
+}
+
+// escape reports how a context passed as argument i of call outlives the call: the
+// callee, or a function it passes the context on to, stores it in a struct or a
+// variable that is not local, sends it on a channel, or hands it to a goroutine or
+// a callback that runs later. It returns "" if none of that is visible in the
+// source of the loaded packages.
+//
+// detach is false if the context escapes into a goroutine: one started for the
+// call may well be meant to stop with it, so it is not detached from cancellation
+// but only flagged for review.
+func (idx funcIndex) escape(fset *token.FileSet, info *types.Info, call *ast.CallExpr, i int) (how string, detach bool) {
+	name, how, pos, detach := idx.escapeArg(fset, info, call, i, map[funcKey]bool{})
+	if how == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s %s at %s:%d", name, how, filepath.Base(pos.Filename), pos.Line), detach
+}
+
+// escapeArg is escape for callees not in seen. It returns the callee's name, how
+// the context escapes and where, and whether to detach it.
+func (idx funcIndex) escapeArg(fset *token.FileSet, info *types.Info, call *ast.CallExpr, i int, seen map[funcKey]bool) (string, string, token.Position, bool) {
+	fn := calledFunc(info, call)
+	if fn == nil {
+		return "", "", token.Position{}, false
+	}
+	key := idx.key(fset, fn.Pos(), fn.Name())
+	callee, ok := idx[key]
+	if !ok || seen[key] {
+		return "", "", token.Position{}, false
+	}
+	seen[key] = true
+	param := paramIdent(callee.decl, i)
+	if param == nil || callee.pkg.TypesInfo.Defs[param] == nil {
+		return "", "", token.Position{}, false
+	}
+	how, pos, detach := idx.escapeParam(callee, callee.pkg.TypesInfo.Defs[param], seen)
+	return funcName(callee.pkg.Name, callee.decl), how, pos, detach
+}
+
+// paramIdent returns the name of the parameter argument i of a call to fd binds
+// to, or nil if it is unnamed or blank.
+func paramIdent(fd *ast.FuncDecl, i int) *ast.Ident {
+	var names []*ast.Ident
+	for _, field := range fd.Type.Params.List {
+		if len(field.Names) == 0 {
+			names = append(names, nil)
+		}
+		names = append(names, field.Names...)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if i >= len(names) {
+		// variadic
+		i = len(names) - 1
+	}
+	if names[i] == nil || names[i].Name == "_" {
+		return nil
+	}
+	return names[i]
+}
+
+// escapeParam looks for the first place in callee where the value of param, or of
+// a local variable derived from it, escapes. Only the context itself flows: results
+// of calls that take it, like ctx.Err() or load(ctx), do not (see flows), and a
+// goroutine that only watches it with ctx.Done(), ctx.Err() or ctx.Deadline() does
+// not keep it either.
+func (idx funcIndex) escapeParam(callee funcDecl, param types.Object, seen map[funcKey]bool) (string, token.Position, bool) {
+	info := callee.pkg.TypesInfo
+	tainted := map[types.Object]bool{param: true}
+	// flows reports whether e evaluates to the context: a tainted variable, a
+	// context.With* call deriving from one, or a function literal capturing one.
+	var flows func(e ast.Expr) bool
+	flows = func(e ast.Expr) bool {
+		switch e := ast.Unparen(e).(type) {
+		case *ast.Ident:
+			return tainted[info.Uses[e]]
+		case *ast.CallExpr:
+			fn := calledFunc(info, e)
+			return fn != nil && fn.Pkg() != nil && fn.Pkg().Path() == "context" && strings.HasPrefix(fn.Name(), "With") &&
+				len(e.Args) > 0 && flows(e.Args[0])
+		case *ast.FuncLit:
+			found := false
+			ast.Inspect(e.Body, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && tainted[info.Uses[id]] {
+					found = true
+				}
+				return !found
+			})
+			return found
+		}
+		return false
+	}
+	// targets returns the left-hand sides rhs[k] is assigned to that take a context;
+	// a call returning several values, like context.WithCancel, is assigned to all.
+	targets := func(lhs []ast.Expr, rhs []ast.Expr, k int) []ast.Expr {
+		if len(lhs) == len(rhs) {
+			return lhs[k : k+1]
+		}
+		tuple, ok := info.TypeOf(rhs[k]).(*types.Tuple)
+		if !ok {
+			return nil
+		}
+		var ls []ast.Expr
+		for j, l := range lhs {
+			if j < tuple.Len() && isContextValue(tuple.At(j).Type()) {
+				ls = append(ls, l)
+			}
+		}
+		return ls
+	}
+	// assign taints a local variable or reports a store elsewhere.
+	assign := func(lhs ast.Expr) string {
+		if id, ok := ast.Unparen(lhs).(*ast.Ident); ok {
+			obj := info.Defs[id]
+			if obj == nil {
+				obj = info.Uses[id]
+			}
+			if obj == nil || id.Name == "_" {
+				return ""
+			}
+			if obj.Parent() != obj.Pkg().Scope() {
+				tainted[obj] = true
+				return ""
+			}
+		}
+		return "stores it in " + types.ExprString(lhs)
+	}
+
+	// watches reports whether the context only reaches the function literal lit of a
+	// go statement as the receiver of cancellation methods.
+	watches := func(lit *ast.FuncLit) bool {
+		watchers := map[*ast.Ident]bool{}
+		ast.Inspect(lit.Body, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if id := cancellationReceiver(call); id != nil {
+					watchers[id] = true
+				}
+			}
+			return true
+		})
+		only := true
+		ast.Inspect(lit.Body, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && tainted[info.Uses[id]] && !watchers[id] {
+				only = false
+			}
+			return only
+		})
+		return only
+	}
+
+	var how string
+	var at token.Pos
+	var pos token.Position // set by calls the context is passed on to
+	detach := true
+	ast.Inspect(callee.decl.Body, func(n ast.Node) bool {
+		if how != "" {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for k, rhs := range n.Rhs {
+				if !flows(rhs) {
+					continue
+				}
+				for _, l := range targets(n.Lhs, n.Rhs, k) {
+					if h := assign(l); h != "" {
+						how, at = h, n.Pos()
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			names := make([]ast.Expr, len(n.Names))
+			for j, name := range n.Names {
+				names[j] = name
+			}
+			for k, v := range n.Values {
+				if !flows(v) {
+					continue
+				}
+				for _, name := range targets(names, n.Values, k) {
+					assign(name)
+				}
+			}
+		case *ast.CompositeLit:
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					elt = kv.Value
+				}
+				if flows(elt) {
+					how, at = "stores it in a composite literal", n.Pos()
+					if n.Type != nil {
+						how = "stores it in a " + types.ExprString(n.Type) + " literal"
+					}
+					return false
+				}
+			}
+		case *ast.SendStmt:
+			if flows(n.Value) {
+				how, at = "sends it on a channel", n.Pos()
+			}
+		case *ast.GoStmt:
+			lit, isLit := n.Call.Fun.(*ast.FuncLit)
+			if (isLit && !watches(lit)) || (!isLit && flows(n.Call.Fun)) || slices.ContainsFunc(n.Call.Args, flows) {
+				how, at, detach = "uses it in a go statement", n.Pos(), false
+			}
+			return false
+		case *ast.CallExpr:
+			if fn := calledFunc(info, n); fn != nil && fn.Pkg() != nil {
+				for _, cb := range asyncCallbacks {
+					if fn.Pkg().Path() == cb.pkgPath && fn.Name() == cb.name && slices.ContainsFunc(n.Args, flows) {
+						how, at = "uses it in a "+cb.pkgPath+"."+cb.name+" callback", n.Pos()
+						return false
+					}
+				}
+			}
+			for k, arg := range n.Args {
+				if flows(arg) {
+					if name, h, p, d := idx.escapeArg(callee.pkg.Fset, info, n, k, seen); h != "" {
+						how, pos, detach = "passes it to "+name+", which "+h, p, d
+						return false
+					}
+				}
+			}
+		}
+		return true
+	})
+	if at.IsValid() {
+		pos = callee.pkg.Fset.Position(at)
+	}
+	return how, pos, detach
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessFileEscapes(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/queue", "example.com/svc"}, map[string]map[string]string{
+		"example.com/queue": {"queue.go": `package queue
+
+import (
+	"context"
+	"time"
+)
+
+type job struct {
+	ctx  context.Context
+	name string
+}
+
+var jobs = make(chan job)
+
+func Publish(ctx context.Context, name string) {
+	j := job{name: name}
+	j.ctx = ctx
+	jobs <- j
+}
+
+func Send(ctx context.Context) {
+	c := ctx
+	ctxs <- c
+}
+
+func Warm(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+	}()
+}
+
+func Spawn(ctx context.Context) {
+	go func() {
+		Do(ctx)
+	}()
+}
+
+func Later(ctx context.Context) {
+	time.AfterFunc(time.Second, func() { Do(ctx) })
+}
+
+func Relay(ctx context.Context) {
+	Do(ctx)
+	Send(ctx)
+}
+
+func Do(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	<-ctx.Done()
+}
+
+var ctxs = make(chan context.Context)
+
+type Store struct {
+	data string
+	err  error
+}
+
+type Resp struct {
+	Err error
+}
+
+// Fill keeps what it computes with the context, not the context.
+func (s *Store) Fill(ctx context.Context) Resp {
+	var err error
+	s.data, err = s.load(ctx)
+	s.err = err
+	return Resp{Err: ctx.Err()}
+}
+
+func (s *Store) load(ctx context.Context) (string, error) {
+	return "", ctx.Err()
+}
+`},
+		"example.com/svc": {"svc.go": `package svc
+
+import (
+	"context"
+	"net/http"
+
+	"example.com/queue"
+)
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	queue.Publish(context.TODO(), "a")
+	queue.Warm(context.TODO())
+	queue.Spawn(context.TODO())
+	queue.Later(context.TODO())
+	queue.Relay(context.TODO())
+	queue.Do(context.TODO())
+	new(queue.Store).Fill(context.TODO())
+}
+`},
+	})
+	funcs := newFuncIndex(pkgs)
+	svc := pkgs[1]
+	findings, unresolved := processFile(svc, svc.Syntax[0], nil, funcs)
+	assert.Empty(t, unresolved)
+	type site struct {
+		line         int
+		text, review string
+	}
+	var got []site
+	for _, f := range findings {
+		got = append(got, site{f.pos.Line, f.text, f.review})
+	}
+	assert.Equal(t, []site{
+		{11, "context.WithoutCancel(r.Context())", "queue.Publish stores it in j.ctx at queue.go:17"},
+		{12, "r.Context()", ""},
+		{13, "r.Context()", "queue.Spawn uses it in a go statement at queue.go:33"},
+		{14, "context.WithoutCancel(r.Context())", "queue.Later uses it in a time.AfterFunc callback at queue.go:39"},
+		{15, "context.WithoutCancel(r.Context())", "queue.Relay passes it to queue.Send, which sends it on a channel at queue.go:23"},
+		{16, "r.Context()", ""},
+		{17, "r.Context()", ""},
+	}, got)
+	assert.Equal(t, []string{"r.Context()"}, findings[0].alts)
+	assert.Equal(t, "context.WithoutCancel(r.Context())", findings[0].edit.text)
+
+	// without context.WithoutCancel, the calls are left for review
+	svc.TypesInfo.FileVersions = map[*ast.File]string{svc.Syntax[0]: "go1.20"}
+	findings, unresolved = processFile(svc, svc.Syntax[0], nil, funcs)
+	assert.Len(t, findings, 4)
+	if assert.Len(t, unresolved, 3) {
+		assert.Equal(t, reasonEscapes, unresolved[0].reason)
+		assert.Equal(t, "queue.Publish stores it in j.ctx at queue.go:17", unresolved[0].detail)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"sort"
+	"strings"
+)
+
+// generatedStats records the skipped generated files and their context.TODO() counts,
+// keyed by file name so a file loaded in several build configurations counts once.
+type generatedStats map[string]generatedFile
+
+type generatedFile struct {
+	generator string
+	todos     int
+}
+
+type generatorCount struct {
+	files int
+	todos int
+}
+
+// add records a generated file and its context.TODO() calls.
+func (g generatedStats) add(filename string, file *ast.File) {
+	todos := 0
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && isContextTODO(call) {
+			todos++
+		}
+		return true
+	})
+	g[filename] = generatedFile{generator: generatorName(file), todos: todos}
+}
+
+// merge adds the files recorded in other.
+func (g generatedStats) merge(other generatedStats) {
+	for filename, gf := range other {
+		g[filename] = gf
+	}
+}
+
+// byGenerator sums files and context.TODO() calls per generator.
+func (g generatedStats) byGenerator() map[string]*generatorCount {
+	counts := map[string]*generatorCount{}
+	for _, gf := range g {
+		c := counts[gf.generator]
+		if c == nil {
+			c = &generatorCount{}
+			counts[gf.generator] = c
+		}
+		c.files++
+		c.todos += gf.todos
+	}
+	return counts
+}
+
+// print writes a per-generator summary, busiest generator first.
+func (g generatedStats) print(w io.Writer) {
+	if len(g) == 0 {
+		return
+	}
+	counts := g.byGenerator()
+	names := make([]string, 0, len(counts))
+	todos := 0
+	for name, c := range counts {
+		names = append(names, name)
+		todos += c.todos
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]].todos != counts[names[j]].todos {
+			return counts[names[i]].todos > counts[names[j]].todos
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprintf(w, "[GENERATED] skipped %d generated files with %d context.TODO() calls\n", len(g), todos)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %d context.TODO() in %d files\n", name, counts[name].todos, counts[name].files)
+	}
+}
+
+// generatorName extracts the tool from a "// Code generated by <tool> ... DO NOT EDIT."
+// header, e.g. "protoc-gen-go" or "MockGen". Headers that do not name one map to "unknown".
+func generatorName(file *ast.File) string {
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			text, ok := strings.CutPrefix(c.Text, "// Code generated ")
+			if !ok || !strings.HasSuffix(text, " DO NOT EDIT.") {
+				continue
+			}
+			text = strings.TrimSuffix(text, " DO NOT EDIT.")
+			text, ok = strings.CutPrefix(text, "by ")
+			if !ok {
+				return "unknown"
+			}
+			if fields := strings.Fields(text); len(fields) > 0 {
+				return strings.TrimRight(fields[0], ".,;:")
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratedStats(t *testing.T) {
+	sources := []string{
+		"// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n\nfunc a() { f(context.TODO()); f(context.TODO()) }\n",
+		"// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: user.proto\n\npackage pb\n\nfunc b() { f(context.TODO()) }\n",
+		"// Code generated by MockGen. DO NOT EDIT.\n\npackage mocks\n",
+		"// Code generated - DO NOT EDIT.\n\npackage x\n\nfunc c() { f(context.TODO()) }\n",
+	}
+	g := generatedStats{}
+	for i, src := range sources {
+		file, err := parser.ParseFile(token.NewFileSet(), "gen.go", src, parser.ParseComments)
+		assert.NoError(t, err)
+		g.add(fmt.Sprintf("gen%d.go", i), file)
+		g.add(fmt.Sprintf("gen%d.go", i), file) // seen again in another build configuration
+	}
+	counts := g.byGenerator()
+	assert.Equal(t, &generatorCount{files: 2, todos: 3}, counts["protoc-gen-go"])
+	assert.Equal(t, &generatorCount{files: 1, todos: 0}, counts["MockGen"])
+	assert.Equal(t, &generatorCount{files: 1, todos: 1}, counts["unknown"])
+
+	var out bytes.Buffer
+	g.print(&out)
+	assert.Equal(t, `[GENERATED] skipped 4 generated files with 4 context.TODO() calls
+  protoc-gen-go: 3 context.TODO() in 2 files
+  unknown: 1 context.TODO() in 1 files
+  MockGen: 0 context.TODO() in 1 files
+`, out.String())
+}
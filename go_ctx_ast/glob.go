@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// isExcluded reports whether filename matches one of the -exclude globs. Names are
+// matched relative to the working directory when possible.
+func isExcluded(filename string) bool {
+	if len(flagExclude) == 0 {
+		return false
+	}
+	name := filename
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+	}
+	name = filepath.ToSlash(name)
+	for _, pat := range flagExclude {
+		if globMatch(pat, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches a slash-separated name against pattern. A pattern without a
+// slash matches any single path element (so "*.pb.go" and "vendor" work anywhere);
+// otherwise the pattern is anchored at the start of name and "**" matches zero or
+// more whole elements.
+func globMatch(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	elems := strings.Split(name, "/")
+	if !strings.Contains(pattern, "/") {
+		for _, e := range elems {
+			if ok, _ := path.Match(pattern, e); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return matchElems(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), elems)
+}
+
+func matchElems(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pat[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
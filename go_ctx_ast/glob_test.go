@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.pb.go", "api/v1/user.pb.go", true},
+		{"*.pb.go", "api/v1/user.go", false},
+		{"vendor", "vendor/github.com/x/y.go", true},
+		{"vendor/**", "vendor/github.com/x/y.go", true},
+		{"vendor/**", "internal/vendor/y.go", false},
+		{"**/mocks/*.go", "internal/svc/mocks/store.go", true},
+		{"**/mocks/*.go", "mocks/store.go", true},
+		{"internal/*.go", "internal/svc/a.go", false},
+		{"./internal/**/*_gen.go", "internal/a/b/x_gen.go", true},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, globMatch(tc.pattern, tc.name), "%s vs %s", tc.pattern, tc.name)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// goSpanCtx is the context a go statement wrapped by goSpan runs with.
+const goSpanCtx = "ctxWithoutCancel"
+
+// goSpanPrelude starts the span of a wrapped go statement; %[1]s is the indentation
+// of the goroutine's body, %[2]s the context it detaches.
+const goSpanPrelude = `%[1]sspan, ctxWithoutCancel := tracer.StartOtelChildSpan(
+%[1]s	context.WithoutCancel(%[2]s),
+%[1]s	tracer.ChildSpanInfo{OperationName: "go-routine"},
+%[1]s)
+%[1]sdefer span.End()`
+
+// goSpan is a go statement that uses the context in scope. The goroutine outlives
+// the caller's context, so it is given a span of its own on a context detached from
+// the caller's cancellation, and uses that instead:
+//
+//	go func() {
+//		span, ctxWithoutCancel := tracer.StartOtelChildSpan(
+//			context.WithoutCancel(ctx),
+//			tracer.ChildSpanInfo{OperationName: "go-routine"},
+//		)
+//		defer span.End()
+//
+//		send(ctxWithoutCancel, msg)
+//	}()
+//
+// `go send(ctx, msg)` becomes the same function literal.
+type goSpan struct {
+	stmt   *ast.GoStmt
+	lit    *ast.FuncLit // the goroutine's function literal, nil for `go f(...)`
+	source string       // the context the span detaches
+	decl   *ast.FuncDecl
+
+	// vars are the context variables whose uses become ctxWithoutCancel: the
+	// source, a ctx parameter of lit, and a ctx the body detaches itself with
+	// `ctx := context.Background()`. avail holds where the latter two come into scope.
+	vars  map[types.Object]bool
+	avail map[token.Pos]bool
+	uses  []*ast.Ident
+	drops []ast.Stmt // the `ctx := context.Background()` statements, deleted
+
+	reps     []replacement // the context.TODO() calls inside the statement
+	detached int           // how many of reps use ctxWithoutCancel
+	text     string        // the rewritten call of stmt, once rendered
+}
+
+// newGoSpan returns the span of gs at site, or nil if the statement has no context
+// to detach or cannot be rewritten in place.
+func newGoSpan(fset *token.FileSet, info *types.Info, src []byte, gs *ast.GoStmt, site callSite) *goSpan {
+	sp := &goSpan{stmt: gs, source: site.ctxExpr, decl: site.decl, vars: map[types.Object]bool{}, avail: map[token.Pos]bool{}}
+	if _, ok := lineIndent(src, fset.Position(gs.Pos()).Offset); !ok {
+		return nil // shares its line with other code
+	}
+	var body ast.Node = gs.Call
+	if lit, ok := gs.Call.Fun.(*ast.FuncLit); ok {
+		sp.lit, body = lit, lit.Body
+		for _, p := range declaredParams(info, lit.Type) {
+			if kind, _ := isContextType(p.typ); p.name.Name == "ctx" && kind == ctxValue {
+				sp.source = "ctx"
+				sp.vars[info.Defs[p.name]] = true
+				sp.avail[p.name.Pos()] = true
+			}
+		}
+		for _, stmt := range lit.Body.List {
+			if id := detachingDecl(info, stmt); id != nil && id.Name == sp.source {
+				sp.drops = append(sp.drops, stmt)
+				sp.vars[info.Defs[id]] = true
+				sp.avail[stmt.End()] = true
+			}
+		}
+	} else if multilineRawString(fset, gs.Call) {
+		return nil // could not be indented into the literal
+	}
+	if sp.source == "" {
+		return nil
+	}
+
+	// `<-ctx.Done()` watches the caller's cancellation and keeps doing so
+	watchers := map[*ast.Ident]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if id := cancellationReceiver(call); id != nil {
+				watchers[id] = true
+			}
+		}
+		return true
+	})
+	ast.Inspect(body, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || watchers[id] {
+			return true
+		}
+		obj := info.Uses[id]
+		if obj == nil {
+			return true
+		}
+		if !sp.vars[obj] && obj.Name() == sp.source && obj.Pos() < gs.Pos() {
+			if v, ok := obj.(*types.Var); ok {
+				if kind, _ := isContextType(v.Type()); kind == ctxValue {
+					sp.vars[obj] = true
+				}
+			}
+		}
+		if sp.vars[obj] {
+			sp.uses = append(sp.uses, id)
+		}
+		return true
+	})
+	return sp
+}
+
+// detaches reports whether a context.TODO() call at site inside the statement
+// takes the detached context: nothing is in scope in the goroutine's body, or
+// site resolves to one of the variables the span replaces.
+func (sp *goSpan) detaches(site callSite) bool {
+	return site.ctxExpr == "" || site.ctxExpr == sp.source && (site.ctxAvail < sp.stmt.Pos() || sp.avail[site.ctxAvail])
+}
+
+// active reports whether the goroutine uses the detached context at all.
+func (sp *goSpan) active() bool {
+	return len(sp.uses) > 0 || sp.detached > 0
+}
+
+// render sets sp.text to the rewritten call of the go statement.
+func (sp *goSpan) render(fset *token.FileSet, file *ast.File, src []byte) {
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	start, end := offset(sp.stmt.Call.Pos()), offset(sp.stmt.Call.End())
+	indent, _ := lineIndent(src, offset(sp.stmt.Pos()))
+	prelude := fmt.Sprintf(goSpanPrelude, indent+"\t", sp.source)
+
+	var edits []edit
+	for _, e := range replacementEdits(fset, file, sp.reps) {
+		edits = append(edits, edit{e.start - start, e.end - start, e.text})
+	}
+	for _, id := range sp.uses {
+		edits = append(edits, edit{offset(id.Pos()) - start, offset(id.End()) - start, goSpanCtx})
+	}
+	for _, stmt := range sp.drops {
+		from := offset(stmt.Pos())
+		if ind, ok := lineIndent(src, from); ok {
+			from -= len(ind)
+		}
+		edits = append(edits, edit{from - start, offset(stmt.End()) - start, ""})
+	}
+	if sp.lit != nil {
+		at := offset(sp.lit.Body.Lbrace) + 1
+		text := "\n" + prelude + "\n"
+		if len(sp.lit.Body.List) > 0 && fset.Position(sp.lit.Body.List[0].Pos()).Line == fset.Position(sp.lit.Body.Lbrace).Line {
+			text += "\n" + indent + "\t"
+		}
+		edits = append(edits, edit{at - start, at - start, text})
+	}
+	out, err := applyEdits(src[start:end], edits)
+	if err != nil {
+		panic(err) // the edits are disjoint spans of the call
+	}
+	if sp.lit != nil {
+		sp.text = string(out)
+		return
+	}
+	call := strings.ReplaceAll(string(out), "\n", "\n\t")
+	sp.text = "func() {\n" + prelude + "\n\n" + indent + "\t" + call + "\n" + indent + "}()"
+}
+
+// detachingDecl returns the name stmt declares if it is `name := context.Background()`.
+func detachingDecl(info *types.Info, stmt ast.Stmt) *ast.Ident {
+	assign, ok := stmt.(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE || len(assign.Lhs) != 1 || len(assign.Rhs) != 1 {
+		return nil
+	}
+	id, ok := assign.Lhs[0].(*ast.Ident)
+	call, isCall := assign.Rhs[0].(*ast.CallExpr)
+	if !ok || !isCall || len(call.Args) > 0 || info.Defs[id] == nil {
+		return nil
+	}
+	if fn := calledFunc(info, call); fn == nil || fn.Pkg() == nil || fn.Pkg().Path() != "context" || fn.Name() != "Background" {
+		return nil
+	}
+	return id
+}
+
+// cancellationReceiver returns x of a call x.Done(), x.Err() or x.Deadline().
+func cancellationReceiver(call *ast.CallExpr) *ast.Ident {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	switch sel.Sel.Name {
+	case "Done", "Err", "Deadline":
+		id, _ := sel.X.(*ast.Ident)
+		return id
+	}
+	return nil
+}
+
+// multilineRawString reports whether n contains a raw string literal spanning
+// several lines.
+func multilineRawString(fset *token.FileSet, n ast.Node) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING && fset.Position(lit.Pos()).Line != fset.Position(lit.End()).Line {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
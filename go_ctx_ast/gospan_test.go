@@ -0,0 +1,73 @@
+package main
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessFileGoSpan(t *testing.T) {
+	src := `package svc
+
+import "context"
+
+func Run(ctx context.Context) {
+	use(context.TODO())
+	go func() {
+		use(context.TODO())
+		use(context.TODO())
+	}()
+	go func() {
+		<-ctx.Done()
+	}()
+}
+
+func use(ctx context.Context) {}
+`
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc"}, map[string]map[string]string{
+		"example.com/svc": {"svc.go": src},
+	})
+	findings, unresolved := processFile(pkgs[0], pkgs[0].Syntax[0], []byte(src), newFuncIndex(pkgs))
+	assert.Empty(t, unresolved)
+	if assert.Len(t, findings, 2) {
+		assert.False(t, findings[0].span)
+		span := findings[1]
+		assert.True(t, span.span)
+		assert.Equal(t, "ctx", span.text)
+		assert.Equal(t, 7, span.pos.Line)
+		assert.Equal(t, []int{8, 9}, []int{span.sites[0].Line, span.sites[1].Line})
+	}
+	out, err := applyEdits([]byte(src), []edit{findings[0].edit, findings[1].edit})
+	assert.NoError(t, err)
+	assert.Equal(t, `package svc
+
+import "context"
+
+func Run(ctx context.Context) {
+	use(ctx)
+	go func() {
+		span, ctxWithoutCancel := tracer.StartOtelChildSpan(
+			context.WithoutCancel(ctx),
+			tracer.ChildSpanInfo{OperationName: "go-routine"},
+		)
+		defer span.End()
+
+		use(ctxWithoutCancel)
+		use(ctxWithoutCancel)
+	}()
+	go func() {
+		<-ctx.Done()
+	}()
+}
+
+func use(ctx context.Context) {}
+`, string(out))
+
+	var got []string
+	for _, s := range buildReport([]fileResult{{filename: "svc.go", findings: findings}}, true).Sites {
+		got = append(got, s.Replacement)
+	}
+	assert.Equal(t, []string{"ctx", goSpanCtx, goSpanCtx}, got)
+}
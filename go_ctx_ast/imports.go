@@ -0,0 +1,78 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// importName returns the name under which file imports path, and whether it does.
+// Dot and blank imports do not count.
+func importName(file *ast.File, path string) (string, bool) {
+	for _, spec := range file.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || p != path {
+			continue
+		}
+		if spec.Name == nil {
+			return defaultImportName(path), true
+		}
+		if spec.Name.Name != "_" && spec.Name.Name != "." {
+			return spec.Name.Name, true
+		}
+	}
+	return "", false
+}
+
+// defaultImportName is the last element of an import path.
+func defaultImportName(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '/' {
+			return path[i+1:]
+		}
+	}
+	return path
+}
+
+// addImportEdit returns the edit that adds `import "path"` to file, keeping an
+// existing import block sorted the way gofmt would, and the name to qualify with.
+// If file already imports path, no edit is needed and ok is false.
+func addImportEdit(fset *token.FileSet, file *ast.File, path string) (e edit, name string, ok bool) {
+	if name, found := importName(file, path); found {
+		return edit{}, name, false
+	}
+	name = defaultImportName(path)
+	offset := func(p token.Pos) int { return fset.Position(p).Offset }
+	quoted := strconv.Quote(path)
+
+	for _, decl := range file.Decls {
+		gd, isGen := decl.(*ast.GenDecl)
+		if !isGen || gd.Tok != token.IMPORT {
+			continue
+		}
+		if !gd.Lparen.IsValid() {
+			// single `import "x"`: add a sibling declaration before or after it
+			spec := gd.Specs[0].(*ast.ImportSpec)
+			if spec.Path.Value > quoted {
+				return edit{start: offset(gd.Pos()), end: offset(gd.Pos()), text: "import " + quoted + "\n"}, name, true
+			}
+			return edit{start: offset(gd.End()), end: offset(gd.End()), text: "\nimport " + quoted}, name, true
+		}
+		// block: insert before the first spec that sorts after path, else after the last
+		var last *ast.ImportSpec
+		for _, s := range gd.Specs {
+			spec := s.(*ast.ImportSpec)
+			if spec.Path.Value > quoted {
+				return edit{start: offset(spec.Pos()), end: offset(spec.Pos()), text: quoted + "\n\t"}, name, true
+			}
+			last = spec
+		}
+		if last == nil {
+			return edit{start: offset(gd.Rparen), end: offset(gd.Rparen), text: "\t" + quoted + "\n"}, name, true
+		}
+		return edit{start: offset(last.End()), end: offset(last.End()), text: "\n\t" + quoted}, name, true
+	}
+	// no imports at all
+	end := offset(file.Name.End())
+	return edit{start: end, end: end, text: "\n\nimport " + quoted}, name, true
+}
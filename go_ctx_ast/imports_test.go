@@ -0,0 +1,37 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddImportEdit(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"package p\n\nfunc f() {}\n", "package p\n\nimport \"context\"\n\nfunc f() {}\n"},
+		{"package p\n\nimport \"fmt\"\n", "package p\n\nimport \"context\"\nimport \"fmt\"\n"},
+		{"package p\n\nimport \"bytes\"\n", "package p\n\nimport \"bytes\"\nimport \"context\"\n"},
+		{"package p\n\nimport (\n\t\"bytes\"\n\t\"fmt\"\n)\n", "package p\n\nimport (\n\t\"bytes\"\n\t\"context\"\n\t\"fmt\"\n)\n"},
+		{"package p\n\nimport (\n\t\"bytes\"\n)\n", "package p\n\nimport (\n\t\"bytes\"\n\t\"context\"\n)\n"},
+		{"package p\n\nimport (\n\t\"context\"\n)\n", "package p\n\nimport (\n\t\"context\"\n)\n"},
+	}
+	for _, tc := range cases {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "p.go", tc.src, parser.ParseComments)
+		assert.NoError(t, err)
+		e, name, ok := addImportEdit(fset, file, "context")
+		assert.Equal(t, "context", name)
+		got := tc.src
+		if ok {
+			out, err := applyEdits([]byte(tc.src), []edit{e})
+			assert.NoError(t, err)
+			got = string(out)
+		}
+		assert.Equal(t, tc.want, got)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decisionsVersion is the format version written to decisions files.
+const decisionsVersion = 1
+
+// Actions recorded in a decisions file.
+const (
+	actionAccept = "accept" // apply the offered replacement
+	actionReject = "reject" // leave context.TODO() in place
+	actionUse    = "use"    // replace with Use instead
+)
+
+// contextLines is how many source lines -interactive shows around a call.
+const contextLines = 2
+
+// decisionsFile records what a reviewer chose for context.TODO() sites under
+// -interactive, so that re-runs apply the same choices without asking. Sites are
+// keyed like the baseline, plus the replacement that was offered: if the context
+// in scope changes, the site is asked about again. Identical calls in one function
+// share a decision.
+type decisionsFile struct {
+	Version   int        `json:"version"`
+	Decisions []decision `json:"decisions"`
+}
+
+type decision struct {
+	Package     string `json:"package"`
+	Function    string `json:"function"`
+	Snippet     string `json:"snippet"`
+	Replacement string `json:"replacement"` // the replacement offered
+	Action      string `json:"action"`
+	Use         string `json:"use,omitempty"` // with actionUse, the chosen alternative
+}
+
+type decisionKey struct {
+	baselineKey
+	replacement string
+}
+
+func (d decision) key() decisionKey {
+	return decisionKey{baselineKey{d.Package, d.Function, d.Snippet}, d.Replacement}
+}
+
+// reviewer filters and adjusts findings by recorded decisions and, when it has an
+// input, asks about the others.
+type reviewer struct {
+	in   *bufio.Reader // nil: only apply recorded decisions
+	out  io.Writer
+	root string // the directory decision packages are relative to
+
+	decisions map[decisionKey]decision
+	changed   bool
+	quit      bool
+}
+
+// newReviewer returns a reviewer applying the decisions recorded at path that asks
+// on stdin about the other sites if interactive is set.
+func newReviewer(path string, interactive bool) (*reviewer, error) {
+	root, err := repoRoot()
+	if err != nil {
+		return nil, err
+	}
+	decisions, err := readDecisions(path)
+	if err != nil {
+		return nil, err
+	}
+	rv := &reviewer{out: os.Stderr, root: root, decisions: decisions}
+	if interactive {
+		rv.in = bufio.NewReader(os.Stdin)
+	}
+	return rv, nil
+}
+
+// save writes the decisions back to path if the reviewer made new ones.
+func (rv *reviewer) save(path string) error {
+	if !rv.changed {
+		return nil
+	}
+	data, err := encodeDecisions(rv.decisions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// readDecisions returns the decisions recorded at path; a missing file records none.
+func readDecisions(path string) (map[decisionKey]decision, error) {
+	decisions := map[decisionKey]decision{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return decisions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var df decisionsFile
+	if err := json.Unmarshal(data, &df); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if df.Version != decisionsVersion {
+		return nil, fmt.Errorf("%s: unsupported decisions version %d", path, df.Version)
+	}
+	for _, d := range df.Decisions {
+		switch d.Action {
+		case actionAccept, actionReject:
+		case actionUse:
+			if d.Use == "" {
+				return nil, fmt.Errorf("%s: %s decision for %q without a replacement", path, actionUse, d.Snippet)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown action %q", path, d.Action)
+		}
+		decisions[d.key()] = d
+	}
+	return decisions, nil
+}
+
+// encodeDecisions returns decisions sorted so that the file diffs well.
+func encodeDecisions(decisions map[decisionKey]decision) ([]byte, error) {
+	df := decisionsFile{Version: decisionsVersion, Decisions: []decision{}}
+	for _, d := range decisions {
+		df.Decisions = append(df.Decisions, d)
+	}
+	sort.Slice(df.Decisions, func(i, j int) bool {
+		a, b := df.Decisions[i], df.Decisions[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Function != b.Function {
+			return a.Function < b.Function
+		}
+		if a.Snippet != b.Snippet {
+			return a.Snippet < b.Snippet
+		}
+		return a.Replacement < b.Replacement
+	})
+	data, err := json.MarshalIndent(df, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// review returns the findings of res to apply. Sites with a recorded decision
+// follow it. Without an input the other findings are kept as they are; with one,
+// each is shown and the answer recorded, and once the reviewer skips the file or
+// quits the remaining ones are left unchanged.
+func (rv *reviewer) review(res fileResult) ([]finding, error) {
+	if len(res.findings) == 0 {
+		return nil, nil
+	}
+	lines, err := readLines(res.filename)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := packageDir(rv.root, res.filename)
+	if err != nil {
+		return nil, err
+	}
+	var kept []finding
+	skipFile := false
+	for _, f := range res.findings {
+		key := decisionKey{baselineKey{dir, f.fn, snippet(lines, f.pos.Line)}, f.text}
+		d, ok := rv.decisions[key]
+		if !ok {
+			if rv.in == nil {
+				kept = append(kept, f)
+				continue
+			}
+			if skipFile || rv.quit {
+				continue
+			}
+			var answered bool
+			d, answered, skipFile = rv.ask(f, lines)
+			if !answered {
+				continue
+			}
+			d.Package, d.Function, d.Snippet, d.Replacement = key.pkg, key.fn, key.snippet, key.replacement
+			rv.decisions[key] = d
+			rv.changed = true
+		}
+		switch d.Action {
+		case actionAccept:
+			kept = append(kept, f)
+		case actionUse:
+			kept = append(kept, f.replaceWith(d.Use))
+		}
+	}
+	return kept, nil
+}
+
+// ask shows f in its source and reads the reviewer's choice. answered is false
+// if the reviewer skipped the rest of the file (skipFile) or quit.
+func (rv *reviewer) ask(f finding, lines [][]byte) (d decision, answered, skipFile bool) {
+	in := ""
+	if f.fn != "" {
+		in = " in " + f.fn
+	}
+	fmt.Fprintf(rv.out, "\n%s:%d%s\n", f.pos.Filename, f.pos.Line, in)
+	for n := max(1, f.pos.Line-contextLines); n <= min(len(lines), f.pos.Line+contextLines); n++ {
+		mark := " "
+		if n == f.pos.Line {
+			mark = ">"
+		}
+		fmt.Fprintf(rv.out, "%s %5d | %s\n", mark, n, lines[n-1])
+	}
+	if f.span {
+		fmt.Fprintf(rv.out, "go statement -> span on context.WithoutCancel(%s)\n", f.text)
+	} else {
+		fmt.Fprintf(rv.out, "context.TODO() -> %s\n", f.text)
+	}
+	options := "[y] accept  [n] reject"
+	for i, alt := range f.alts {
+		options += fmt.Sprintf("  [%d] %s", i+1, alt)
+	}
+	options += "  [s] skip rest of file  [q] quit"
+
+	for {
+		fmt.Fprintf(rv.out, "%s? ", options)
+		answer, err := rv.in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && answer == "" {
+			// end of input
+			fmt.Fprintln(rv.out)
+			rv.quit = true
+			return decision{}, false, false
+		}
+		switch answer {
+		case "":
+			continue
+		case "y", "Y":
+			return decision{Action: actionAccept}, true, false
+		case "n", "N":
+			return decision{Action: actionReject}, true, false
+		case "s", "S":
+			return decision{}, false, true
+		case "q", "Q":
+			rv.quit = true
+			return decision{}, false, false
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(f.alts) {
+			return decision{Action: actionUse, Use: f.alts[i-1]}, true, false
+		}
+		fmt.Fprintf(rv.out, "unknown answer %q\n", answer)
+	}
+}
+
+// replaceWith returns f rewriting the call to expr instead, keeping the comments
+// the original replacement carries over.
+func (f finding) replaceWith(expr string) finding {
+	f.edit.text = expr + strings.TrimPrefix(f.edit.text, f.text)
+	f.text = expr
+	return f
+}
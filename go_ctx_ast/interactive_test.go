@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessFileAlternatives(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc"}, map[string]map[string]string{
+		"example.com/svc": {"svc.go": `package svc
+
+import (
+	"context"
+	"net/http"
+)
+
+func Handle(ctx context.Context, r *http.Request) {
+	use(context.TODO())
+}
+
+func use(ctx context.Context) {}
+`},
+	})
+	findings, _ := processFile(pkgs[0], pkgs[0].Syntax[0], nil, newFuncIndex(pkgs))
+	if assert.Len(t, findings, 1) {
+		assert.Equal(t, "ctx", findings[0].text)
+		assert.Equal(t, []string{"r.Context()", "context.WithoutCancel(ctx)"}, findings[0].alts)
+	}
+}
+
+func TestReviewer(t *testing.T) {
+	root := t.TempDir()
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "svc"), 0o755))
+	file := filepath.Join(root, "svc", "a.go")
+	src := "package svc\n\nfunc A(ctx context.Context, r *http.Request) {\n\tuse(context.TODO())\n\tsend(context.TODO())\n\tstore(context.TODO())\n}\n"
+	assert.NoError(t, os.WriteFile(file, []byte(src), 0o644))
+
+	res := fileResult{filename: file}
+	for line, call := range map[int]string{4: "use", 5: "send", 6: "store"} {
+		off := strings.Index(src, call+"(") + len(call) + 1
+		res.findings = append(res.findings, finding{
+			pos:  token.Position{Filename: file, Line: line, Offset: off},
+			text: "ctx",
+			edit: edit{start: off, end: off + len("context.TODO()"), text: "ctx /* why */"},
+			fn:   "svc.A",
+			alts: []string{"r.Context()", "context.WithoutCancel(ctx)"},
+		})
+	}
+	sortFindings(res.findings)
+
+	var out bytes.Buffer
+	rv := &reviewer{in: bufio.NewReader(strings.NewReader("x\n2\nn\ns\n")), out: &out, root: root, decisions: map[decisionKey]decision{}}
+	kept, err := rv.review(res)
+	assert.NoError(t, err)
+	if assert.Len(t, kept, 1) {
+		assert.Equal(t, "context.WithoutCancel(ctx)", kept[0].text)
+		assert.Equal(t, "context.WithoutCancel(ctx) /* why */", kept[0].edit.text)
+	}
+	assert.Contains(t, out.String(), ">     4 | \tuse(context.TODO())")
+	assert.Contains(t, out.String(), "[1] r.Context()  [2] context.WithoutCancel(ctx)")
+	assert.Contains(t, out.String(), `unknown answer "x"`)
+	assert.True(t, rv.changed)
+
+	// the skipped site is not recorded
+	path := filepath.Join(root, "decisions.json")
+	assert.NoError(t, rv.save(path))
+	decisions, err := readDecisions(path)
+	assert.NoError(t, err)
+	assert.Len(t, decisions, 2)
+	assert.Equal(t, decision{Package: "svc", Function: "svc.A", Snippet: "send(context.TODO())", Replacement: "ctx", Action: actionReject},
+		decisions[decisionKey{baselineKey{"svc", "svc.A", "send(context.TODO())"}, "ctx"}])
+
+	// a re-run applies the recorded decisions without asking and keeps the rest
+	rv = &reviewer{root: root, decisions: decisions}
+	kept, err = rv.review(res)
+	assert.NoError(t, err)
+	if assert.Len(t, kept, 2) {
+		assert.Equal(t, "context.WithoutCancel(ctx)", kept[0].text)
+		assert.Equal(t, 6, kept[1].pos.Line)
+	}
+	assert.False(t, rv.changed)
+
+	// a different replacement is asked about again
+	res.findings[1].text = "r.Context()"
+	rv = &reviewer{in: bufio.NewReader(strings.NewReader("q\n")), out: &out, root: root, decisions: decisions}
+	kept, err = rv.review(res)
+	assert.NoError(t, err)
+	assert.Len(t, kept, 1)
+	assert.True(t, rv.quit)
+}
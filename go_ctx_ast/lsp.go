@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// runLSP implements the lsp subcommand: a language server on stdin and stdout that
+// reports the context.TODO() calls of open files as diagnostics and fixes them with
+// code actions. Flags (-tags, -tests, -ctx-accessors, ...) apply as in batch runs.
+func runLSP(args []string) int {
+	flag.CommandLine.Parse(args)
+	s := newLSPServer(os.Stdin, os.Stdout, loadOverlay)
+	if err := s.serve(); err != nil {
+		log.Printf("lsp: %v", err)
+		return 1
+	}
+	if !s.shutdown {
+		return 1 // exit without shutdown, as the protocol asks
+	}
+	return 0
+}
+
+// loadOverlay loads patterns from dir, reading open files from overlay instead of
+// the disk. Test files are always loaded: editors open them too, and adding a
+// parameter must reach their callers.
+func loadOverlay(dir string, overlay map[string][]byte, patterns ...string) ([]*packages.Package, error) {
+	cfg := buildConfig{tags: flagTags}.packagesConfig()
+	cfg.Dir, cfg.Overlay, cfg.Tests = dir, overlay, true
+	return loadPackages(cfg, patterns...)
+}
+
+// lspServer speaks just enough of the Language Server Protocol over JSON-RPC for
+// editors to show and fix context.TODO() calls while editing. Every open file is
+// analysed with processFile when it is opened and again after each change; the
+// unsaved text of open files is given to the go tool as an overlay.
+type lspServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	load func(dir string, overlay map[string][]byte, patterns ...string) ([]*packages.Package, error)
+
+	root     string             // workspace directory, from initialize
+	docs     map[string]*lspDoc // open documents, by filename
+	shutdown bool
+}
+
+// lspDoc is an open document and the result of its last analysis.
+type lspDoc struct {
+	uri        string
+	version    int
+	text       []byte
+	findings   []finding
+	unresolved []lspSite
+}
+
+// lspSite is a context.TODO() call left in place, with the call's extent.
+type lspSite struct {
+	unresolvedSite
+	start, end int // offsets of the call
+}
+
+func newLSPServer(in io.Reader, out io.Writer, load func(string, map[string][]byte, ...string) ([]*packages.Package, error)) *lspServer {
+	return &lspServer{in: bufio.NewReader(in), out: out, load: load, docs: map[string]*lspDoc{}}
+}
+
+// JSON-RPC and LSP messages, limited to the fields the server uses.
+type (
+	rpcMessage struct {
+		JSONRPC string           `json:"jsonrpc"`
+		ID      *json.RawMessage `json:"id,omitempty"`
+		Method  string           `json:"method,omitempty"`
+		Params  json.RawMessage  `json:"params,omitempty"`
+	}
+	rpcError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+	lspTextEdit struct {
+		Range   lspRange `json:"range"`
+		NewText string   `json:"newText"`
+	}
+	lspWorkspaceEdit struct {
+		Changes map[string][]lspTextEdit `json:"changes"`
+	}
+	lspDiagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Code     string   `json:"code,omitempty"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+	lspCodeAction struct {
+		Title       string            `json:"title"`
+		Kind        string            `json:"kind"`
+		Diagnostics []lspDiagnostic   `json:"diagnostics,omitempty"`
+		IsPreferred bool              `json:"isPreferred,omitempty"`
+		Edit        *lspWorkspaceEdit `json:"edit,omitempty"`
+		Data        *lspAddParam      `json:"data,omitempty"`
+	}
+	// lspAddParam is the data of an "add ctx parameter" action, whose edit is only
+	// computed when the editor resolves it: it loads the whole workspace.
+	lspAddParam struct {
+		URI  string `json:"uri"`
+		Line int    `json:"line"` // 1-based, as add-ctx-param takes it
+	}
+
+	lspDocumentID struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+		Text    string `json:"text"`
+	}
+	lspChange struct {
+		Range *lspRange `json:"range"`
+		Text  string    `json:"text"`
+	}
+)
+
+// Diagnostic severities.
+const (
+	lspWarning     = 2
+	lspInformation = 3
+)
+
+// JSON-RPC error codes.
+const (
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcRequestFailed  = -32803
+)
+
+// serve handles messages until exit or the end of the input.
+func (s *lspServer) serve() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return fmt.Errorf("bad message: %v", err)
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			if rerr != nil {
+				log.Printf("lsp: %s: %s", msg.Method, rerr.Message)
+			}
+			continue // a notification
+		}
+		resp := map[string]any{"jsonrpc": "2.0", "id": msg.ID}
+		if rerr != nil {
+			resp["error"] = rerr
+		} else {
+			resp["result"] = result
+		}
+		if err := writeMessage(s.out, resp); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a request or notification and returns its result.
+func (s *lspServer) handle(method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "initialize":
+		var p struct {
+			RootURI string `json:"rootUri"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		s.root = uriFilename(p.RootURI)
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   map[string]any{"openClose": true, "change": 2, "save": true}, // incremental
+				"codeActionProvider": map[string]any{"codeActionKinds": []string{"quickfix"}, "resolveProvider": true},
+			},
+			"serverInfo": map[string]string{"name": "go_ctx_ast"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument lspDocumentID `json:"textDocument"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		doc := &lspDoc{uri: p.TextDocument.URI, version: p.TextDocument.Version, text: []byte(p.TextDocument.Text)}
+		s.docs[uriFilename(doc.uri)] = doc
+		return nil, s.analyze(doc)
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   lspDocumentID `json:"textDocument"`
+			ContentChanges []lspChange   `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		doc := s.docs[uriFilename(p.TextDocument.URI)]
+		if doc == nil {
+			return nil, &rpcError{rpcInvalidParams, p.TextDocument.URI + " is not open"}
+		}
+		for _, c := range p.ContentChanges {
+			if c.Range == nil {
+				doc.text = []byte(c.Text)
+				continue
+			}
+			start, end := byteOffset(doc.text, c.Range.Start), byteOffset(doc.text, c.Range.End)
+			doc.text = append(doc.text[:start:start], append([]byte(c.Text), doc.text[end:]...)...)
+		}
+		doc.version = p.TextDocument.Version
+		return nil, s.analyze(doc)
+	case "textDocument/didSave":
+		var p struct {
+			TextDocument lspDocumentID `json:"textDocument"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		// other files may have changed on disk, the package with them
+		if doc := s.docs[uriFilename(p.TextDocument.URI)]; doc != nil {
+			return nil, s.analyze(doc)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument lspDocumentID `json:"textDocument"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		delete(s.docs, uriFilename(p.TextDocument.URI))
+		return nil, s.publish(p.TextDocument.URI, nil, []lspDiagnostic{})
+	case "textDocument/codeAction":
+		var p struct {
+			TextDocument lspDocumentID `json:"textDocument"`
+			Range        lspRange      `json:"range"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		doc := s.docs[uriFilename(p.TextDocument.URI)]
+		if doc == nil {
+			return []lspCodeAction{}, nil
+		}
+		return s.codeActions(doc, byteOffset(doc.text, p.Range.Start), byteOffset(doc.text, p.Range.End)), nil
+	case "codeAction/resolve":
+		var action lspCodeAction
+		if err := json.Unmarshal(params, &action); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if action.Data == nil {
+			return action, nil
+		}
+		we, err := s.addParamEdit(uriFilename(action.Data.URI), action.Data.Line)
+		if err != nil {
+			return nil, &rpcError{rpcRequestFailed, err.Error()}
+		}
+		action.Edit = we
+		return action, nil
+	}
+	if strings.HasPrefix(method, "$/") {
+		return nil, nil // optional notifications may be ignored
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not supported: " + method}
+}
+
+// overlay returns the unsaved text of the open documents.
+func (s *lspServer) overlay() map[string][]byte {
+	overlay := map[string][]byte{}
+	for filename, doc := range s.docs {
+		overlay[filename] = doc.text
+	}
+	return overlay
+}
+
+// analyze loads the package of doc with the current text of the open documents,
+// runs processFile on doc and publishes its diagnostics.
+func (s *lspServer) analyze(doc *lspDoc) *rpcError {
+	filename := uriFilename(doc.uri)
+	pkgs, err := s.load(s.root, s.overlay(), "file="+filename)
+	if err != nil {
+		return &rpcError{rpcRequestFailed, err.Error()}
+	}
+	doc.findings, doc.unresolved = nil, nil
+	funcs := newFuncIndex(pkgs)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			if pkg.TypesInfo == nil || pkg.Fset.File(file.Pos()).Name() != filename {
+				continue
+			}
+			findings, unresolved := processFile(pkg, file, doc.text, funcs)
+			doc.findings = findings
+			calls := map[token.Pos]*ast.CallExpr{}
+			ast.Inspect(file, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok {
+					calls[call.Pos()] = call
+				}
+				return true
+			})
+			tf := pkg.Fset.File(file.Pos())
+			for _, u := range unresolved {
+				if call := calls[tf.Pos(u.pos.Offset)]; call != nil && u.reason != reasonSuppressed {
+					doc.unresolved = append(doc.unresolved, lspSite{u, u.pos.Offset, tf.Offset(call.End())})
+				}
+			}
+			return s.publish(doc.uri, &doc.version, s.diagnostics(doc))
+		}
+	}
+	return &rpcError{rpcRequestFailed, "no package contains " + filename}
+}
+
+// diagnostics describes the context.TODO() calls of doc.
+func (s *lspServer) diagnostics(doc *lspDoc) []lspDiagnostic {
+	diags := []lspDiagnostic{}
+	for _, f := range doc.findings {
+		msg := "context.TODO() can be replaced with " + f.text
+		if f.span {
+			msg = "the goroutine can run in a span on context.WithoutCancel(" + f.text + ")"
+		}
+		if f.review != "" {
+			msg += ": " + f.review
+		}
+		diags = append(diags, lspDiagnostic{
+			Range: doc.span(f.edit.start, f.edit.end), Severity: lspWarning,
+			Code: statusReplaceable, Source: "go_ctx_ast", Message: msg,
+		})
+	}
+	for _, u := range doc.unresolved {
+		msg := "context.TODO() is left in place: " + u.reason
+		if u.detail != "" {
+			msg += ": " + u.detail
+		}
+		diags = append(diags, lspDiagnostic{
+			Range: doc.span(u.start, u.end), Severity: lspInformation,
+			Code: statusUnresolved, Source: "go_ctx_ast", Message: msg,
+		})
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return positionBefore(diags[i].Range.Start, diags[j].Range.Start) })
+	return diags
+}
+
+func (s *lspServer) publish(uri string, version *int, diags []lspDiagnostic) *rpcError {
+	params := map[string]any{"uri": uri, "diagnostics": diags}
+	if version != nil {
+		params["version"] = *version
+	}
+	if err := writeMessage(s.out, map[string]any{"jsonrpc": "2.0", "method": "textDocument/publishDiagnostics", "params": params}); err != nil {
+		return &rpcError{rpcRequestFailed, err.Error()}
+	}
+	return nil
+}
+
+// codeActions returns the fixes of the sites of doc overlapping [start, end]: one
+// per context in scope, best first, and for calls with none, adding a ctx
+// parameter to the enclosing function.
+func (s *lspServer) codeActions(doc *lspDoc, start, end int) []lspCodeAction {
+	actions := []lspCodeAction{}
+	for _, f := range doc.findings {
+		if f.edit.end < start || f.edit.start > end {
+			continue
+		}
+		if f.span {
+			actions = append(actions, lspCodeAction{
+				Title: "Run in a span on context.WithoutCancel(" + f.text + ")", Kind: "quickfix", IsPreferred: true,
+				Edit: &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{
+					doc.uri: {{Range: doc.span(f.edit.start, f.edit.end), NewText: f.edit.text}},
+				}},
+			})
+			continue
+		}
+		for i, expr := range append([]string{f.text}, f.alts...) {
+			alt := f.replaceWith(expr)
+			actions = append(actions, lspCodeAction{
+				Title: "Use " + expr, Kind: "quickfix", IsPreferred: i == 0,
+				Edit: &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{
+					doc.uri: {{Range: doc.span(alt.edit.start, alt.edit.end), NewText: alt.edit.text}},
+				}},
+			})
+		}
+	}
+	for _, u := range doc.unresolved {
+		if u.end < start || u.start > end || u.reason != reasonNoContext || u.fn == "" {
+			continue
+		}
+		actions = append(actions, lspCodeAction{
+			Title: "Add ctx parameter to " + u.fn, Kind: "quickfix",
+			Data: &lspAddParam{URI: doc.uri, Line: u.pos.Line},
+		})
+	}
+	return actions
+}
+
+// addParamEdit loads the workspace and returns the edit of add-ctx-param at
+// filename:line.
+func (s *lspServer) addParamEdit(filename string, line int) (*lspWorkspaceEdit, error) {
+	overlay := s.overlay()
+	pkgs, err := s.load(s.root, overlay, "./...")
+	if err != nil {
+		return nil, err
+	}
+	results, err := addContextParam(pkgs, filename, line)
+	if err != nil {
+		return nil, err
+	}
+	we := &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{}}
+	for _, res := range results {
+		src, ok := overlay[res.filename]
+		if !ok {
+			if src, err = os.ReadFile(res.filename); err != nil {
+				return nil, err
+			}
+		}
+		uri := filenameURI(res.filename)
+		for _, f := range res.findings {
+			we.Changes[uri] = append(we.Changes[uri], lspTextEdit{
+				Range:   lspRange{positionAt(src, f.edit.start), positionAt(src, f.edit.end)},
+				NewText: f.edit.text,
+			})
+		}
+	}
+	return we, nil
+}
+
+func (doc *lspDoc) span(start, end int) lspRange {
+	return lspRange{positionAt(doc.text, start), positionAt(doc.text, end)}
+}
+
+// positionAt converts a byte offset of src to an LSP position, whose character
+// counts UTF-16 code units.
+func positionAt(src []byte, offset int) lspPosition {
+	offset = min(offset, len(src))
+	var p lspPosition
+	lineStart := 0
+	for i := 0; i < offset; i++ {
+		if src[i] == '\n' {
+			p.Line++
+			lineStart = i + 1
+		}
+	}
+	for _, r := range string(src[lineStart:offset]) {
+		p.Character += utf16.RuneLen(r)
+	}
+	return p
+}
+
+// byteOffset converts an LSP position in src to a byte offset, clamped to the
+// end of its line.
+func byteOffset(src []byte, p lspPosition) int {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		i := strings.IndexByte(string(src[offset:]), '\n')
+		if i < 0 {
+			return len(src)
+		}
+		offset += i + 1
+	}
+	for units := 0; units < p.Character && offset < len(src) && src[offset] != '\n'; {
+		r, size := utf8.DecodeRune(src[offset:])
+		units += utf16.RuneLen(r)
+		offset += size
+	}
+	return offset
+}
+
+func positionBefore(a, b lspPosition) bool {
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Character < b.Character
+}
+
+// uriFilename returns the path of a file: URI.
+func uriFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func filenameURI(filename string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
+
+// readMessage reads one message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// writeMessage writes v as a message framed by a Content-Length header.
+func writeMessage(w io.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+func TestLSPServer(t *testing.T) {
+	const filename = "/work/svc/svc.go"
+	uri := filenameURI(filename)
+	src := `package svc
+
+import (
+	"context"
+	"net/http"
+)
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	do(context.TODO())
+}
+
+func Run() {
+	do(context.TODO())
+}
+
+func do(ctx context.Context) {}
+`
+	load := func(dir string, overlay map[string][]byte, patterns ...string) ([]*packages.Package, error) {
+		assert.Equal(t, "/work", dir)
+		return testPackages(t, token.NewFileSet(), []string{"example.com/svc"}, map[string]map[string]string{
+			"example.com/svc": {filename: string(overlay[filename])},
+		}), nil
+	}
+
+	var in bytes.Buffer
+	id := 0
+	send := func(method string, params any, request bool) {
+		msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+		if request {
+			id++
+			msg["id"] = id
+		}
+		assert.NoError(t, writeMessage(&in, msg))
+	}
+	send("initialize", map[string]any{"rootUri": filenameURI("/work")}, true)
+	send("initialized", map[string]any{}, false)
+	send("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "version": 1, "languageId": "go", "text": src}}, false)
+	send("textDocument/codeAction", map[string]any{"textDocument": map[string]any{"uri": uri}, "range": lspRange{lspPosition{8, 0}, lspPosition{12, 10}}}, true)
+	send("codeAction/resolve", map[string]any{"title": "Add ctx parameter to svc.Run", "kind": "quickfix", "data": lspAddParam{URI: uri, Line: 13}}, true)
+	// type a context into Run: its call is now replaceable
+	send("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []lspChange{{Range: &lspRange{lspPosition{11, 9}, lspPosition{11, 9}}, Text: "ctx context.Context"}},
+	}, false)
+	send("shutdown", nil, true)
+	send("exit", nil, false)
+
+	var out bytes.Buffer
+	s := newLSPServer(&in, &out, load)
+	assert.NoError(t, s.serve())
+	assert.True(t, s.shutdown)
+
+	type message struct {
+		ID     int             `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+	}
+	var msgs []message
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		var m message
+		assert.NoError(t, json.Unmarshal(body, &m))
+		msgs = append(msgs, m)
+	}
+	if !assert.Len(t, msgs, 6) {
+		return
+	}
+
+	type published struct {
+		Version     int             `json:"version"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	var diags published
+	assert.Equal(t, "textDocument/publishDiagnostics", msgs[1].Method)
+	assert.NoError(t, json.Unmarshal(msgs[1].Params, &diags))
+	assert.Equal(t, published{1, []lspDiagnostic{
+		{Range: lspRange{lspPosition{8, 4}, lspPosition{8, 18}}, Severity: lspWarning, Code: statusReplaceable, Source: "go_ctx_ast", Message: "context.TODO() can be replaced with r.Context()"},
+		{Range: lspRange{lspPosition{12, 4}, lspPosition{12, 18}}, Severity: lspInformation, Code: statusUnresolved, Source: "go_ctx_ast", Message: "context.TODO() is left in place: " + reasonNoContext},
+	}}, diags)
+
+	var actions []lspCodeAction
+	assert.Equal(t, 2, msgs[2].ID)
+	assert.NoError(t, json.Unmarshal(msgs[2].Result, &actions))
+	assert.Equal(t, []lspCodeAction{
+		{Title: "Use r.Context()", Kind: "quickfix", IsPreferred: true, Edit: &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{
+			uri: {{Range: lspRange{lspPosition{8, 4}, lspPosition{8, 18}}, NewText: "r.Context()"}},
+		}}},
+		{Title: "Use context.WithoutCancel(r.Context())", Kind: "quickfix", Edit: &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{
+			uri: {{Range: lspRange{lspPosition{8, 4}, lspPosition{8, 18}}, NewText: "context.WithoutCancel(r.Context())"}},
+		}}},
+		{Title: "Add ctx parameter to svc.Run", Kind: "quickfix", Data: &lspAddParam{URI: uri, Line: 13}},
+	}, actions)
+
+	var resolved lspCodeAction
+	assert.NoError(t, json.Unmarshal(msgs[3].Result, &resolved))
+	assert.Equal(t, map[string][]lspTextEdit{uri: {
+		{Range: lspRange{lspPosition{11, 9}, lspPosition{11, 9}}, NewText: "ctx context.Context"},
+		{Range: lspRange{lspPosition{12, 4}, lspPosition{12, 18}}, NewText: "ctx"},
+	}}, resolved.Edit.Changes)
+
+	assert.NoError(t, json.Unmarshal(msgs[4].Params, &diags))
+	assert.Equal(t, 2, diags.Version)
+	if assert.Len(t, diags.Diagnostics, 2) {
+		assert.Equal(t, "context.TODO() can be replaced with ctx", diags.Diagnostics[1].Message)
+	}
+	assert.Equal(t, 4, msgs[5].ID)
+}
+
+func TestLSPPositions(t *testing.T) {
+	src := []byte("a\n\tx := \"héllo😀\" // y\n")
+	for _, c := range []struct {
+		offset int
+		pos    lspPosition
+	}{
+		{0, lspPosition{0, 0}},
+		{2, lspPosition{1, 0}},
+		{len("a\n\tx := \"héllo"), lspPosition{1, 12}},
+		{len("a\n\tx := \"héllo😀"), lspPosition{1, 14}},
+	} {
+		assert.Equal(t, c.pos, positionAt(src, c.offset))
+		assert.Equal(t, c.offset, byteOffset(src, c.pos))
+	}
+	// characters past the end of a line stay on it
+	assert.Equal(t, 1, byteOffset(src, lspPosition{0, 5}))
+}
@@ -1,25 +1,53 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"go/ast"
-	"go/printer"
+	"go/importer"
+	"go/parser"
 	"go/token"
 	"go/types"
 
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 
-	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
 )
 
 var (
 	flagNoGoroutines bool
 	flagDryRun       bool
+	flagJobs         int
+	flagExclude      stringList
+	flagTests        bool
+	flagTestContext  bool
+
+	flagIncludeGenerated bool
+	flagTags             string
+	flagPlatforms        string
+	flagMigratePtrCtx    bool
+	flagReceiverCtx      bool
+	flagCtxAccessors     string
+	flagLintStructCtx    bool
+	flagLintShadowCtx    bool
+	flagLintCtxParams    bool
+	flagFix              bool
+	flagSince            string
+	flagScoreboard       string
+	flagBaseline         string
+	flagInteractive      bool
+	flagDecisions        string
+	flagJSON             bool
+	flagTimeouts         stringList
+	flagCache            bool
 )
 
 type ctxKind int
@@ -30,115 +58,422 @@ const (
 	ctxPointer         // *context.Context
 )
 
-// scopeFrame represents the availability of ctx and r at/after certain positions.
-// Availability positions are token.Pos values within the file's FileSet.
-type scopeFrame struct {
-	// ctxKind and ctxAvailPos indicate whether `ctx` is available (value or pointer)
-	// and from which position onward (the identifier position).
-	ctxKind     ctxKind
-	ctxAvailPos token.Pos
-
-	// rPresent and rAvailPos indicate whether `r` (type *http.Request) is available.
-	rPresent  bool
-	rAvailPos token.Pos
-}
-
-// skipInterval marks ranges (pos..end) inside which we must not rewrite (anonymous goroutine bodies).
-type skipInterval struct {
-	start token.Pos
-	end   token.Pos
-}
-
 func init() {
 	flag.BoolVar(&flagNoGoroutines, "no-goroutines", false, "Skip rewriting inside goroutines")
 	flag.BoolVar(&flagDryRun, "dry-run", false, "Print replacements but do not write files")
+	flag.IntVar(&flagJobs, "j", runtime.NumCPU(), "Number of packages processed concurrently")
+	flag.BoolVar(&flagTests, "tests", false, "Also load and rewrite _test.go files")
+	flag.BoolVar(&flagTestContext, "test-context", false, "Use t.Context() (Go 1.24+) when a *testing.T/B/F is in scope and nothing better is")
+	flag.BoolVar(&flagIncludeGenerated, "include-generated", false, "Also rewrite files with a \"Code generated ... DO NOT EDIT.\" header")
+	flag.StringVar(&flagTags, "tags", "", "Comma-separated build tags to load packages with")
+	flag.StringVar(&flagPlatforms, "platforms", "", "Comma-separated GOOS/GOARCH[/cgo] list to load and cross-check, e.g. linux/amd64,darwin/arm64")
+	flag.BoolVar(&flagMigratePtrCtx, "migrate-ptr-ctx", false, "Change *context.Context parameters and struct fields to context.Context and update their uses instead of rewriting context.TODO()")
+	flag.BoolVar(&flagReceiverCtx, "receiver-ctx", false, "Fall back to a context.Context field of the method receiver (s.ctx) when nothing else is in scope")
+	flag.StringVar(&flagCtxAccessors, "ctx-accessors", "", "Comma-separated method names, e.g. Context,Ctx: a value in scope whose type has such a method returning context.Context is a context source when no better one is")
+	flag.BoolVar(&flagLintStructCtx, "lint-struct-ctx", false, "Report struct types that store a context.Context instead of rewriting context.TODO()")
+	flag.BoolVar(&flagLintShadowCtx, "lint-shadow-ctx", false, "Report context variables redeclared with := in an inner block while the outer one is used after it")
+	flag.BoolVar(&flagLintCtxParams, "lint-ctx-params", false, "Report context parameters that unexported functions never use, and context parameters that are not first")
+	flag.BoolVar(&flagFix, "fix", false, "With -lint-struct-ctx, pass the context to the methods that read the field and remove it; with -lint-shadow-ctx, assign the outer variable with = outside loops; with -lint-ctx-params, remove or move the parameter and update the calls")
+	flag.StringVar(&flagSince, "since", "", "Only rewrite or report calls on lines changed since this git revision (or in a range like main...HEAD)")
+	flag.StringVar(&flagScoreboard, "scoreboard", "", "Write remaining context.TODO() calls per CODEOWNERS entry as \"markdown\" or \"csv\" instead of rewriting")
+	flag.StringVar(&flagBaseline, "baseline", ".ctxast-baseline.json", "Baseline file for the check and baseline update subcommands")
+	flag.BoolVar(&flagInteractive, "interactive", false, "Show each replacement in its source and ask whether to apply it, keep context.TODO() or use another context")
+	flag.StringVar(&flagDecisions, "decisions", ".ctxast-decisions.json", "File recording -interactive answers; recorded answers are applied on every run")
+	flag.BoolVar(&flagJSON, "json", false, "Print a JSON report of every context.TODO() call, including unresolved and suppressed ones, instead of one line per replacement")
+	flag.Var(&flagTimeouts, "timeout", "Wrap calls to FUNC that have no deadline in context.WithTimeout instead of rewriting context.TODO(), as FUNC=DURATION, e.g. '(*database/sql.DB).QueryContext=5s' (repeatable)")
+	flag.BoolVar(&flagCache, "cache", false, "Reuse the results of unchanged packages from earlier runs, kept under $XDG_CACHE_HOME/go_ctx_ast; only changed packages are type-checked")
+	flag.Var(&flagExclude, "exclude", "Skip files matching this glob (repeatable; ** matches any number of directories)")
 }
 
 func main() {
 	log.SetFlags(0)
+	// Subcommands: check and baseline update analyse like the default mode but
+	// compare the remaining calls with a baseline instead of rewriting.
+	args := os.Args[1:]
+	check, updateBaseline, addParam := false, false, false
+	subcommand := ""
+	switch {
+	case len(args) > 0 && args[0] == "split":
+		os.Exit(runSplit(args[1:]))
+	case len(args) > 0 && args[0] == "lsp":
+		os.Exit(runLSP(args[1:]))
+	case len(args) > 0 && args[0] == "add-ctx-param":
+		addParam, subcommand, args = true, args[0], args[1:]
+	case len(args) > 0 && args[0] == "check":
+		check, subcommand, args = true, args[0], args[1:]
+	case len(args) > 1 && args[0] == "baseline" && args[1] == "update":
+		updateBaseline, subcommand, args = true, "baseline update", args[2:]
+	}
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <file-or-dir>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <package-pattern-or-file>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s check [flags] <package-pattern-or-file>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s baseline update [flags] <package-pattern-or-file>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s split [flags] <branch-prefix>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s add-ctx-param [flags] <file.go:line> [package-pattern]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lsp [flags]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	if flag.NArg() == 0 {
 		flag.Usage()
 		os.Exit(2)
 	}
+	if err := checkFlags(subcommand); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	var files []string
-
-	// Collect files
-	for _, arg := range flag.Args() {
-		info, err := os.Stat(arg)
-		if err != nil {
-			log.Fatalf("stat %s: %v", arg, err)
+	// add-ctx-param takes the position first; callers are looked for in the
+	// packages that follow, the main module by default.
+	args = flag.Args()
+	var at string
+	if addParam {
+		at, args = args[0], args[1:]
+		if len(args) == 0 {
+			args = []string{"./..."}
 		}
+	}
 
-		if info.IsDir() {
-			// Recursively collect .go files
-			err := filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+	// Arguments are package patterns (./..., import paths, directories) passed straight
+	// to the go tool; only explicit .go files need a file= query.
+	var patterns []string
+	for _, arg := range args {
+		if strings.HasSuffix(arg, ".go") {
+			if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+				abs, err := filepath.Abs(arg)
 				if err != nil {
-					return err
+					log.Fatalf("abs %s: %v", arg, err)
 				}
-				if !d.IsDir() && strings.HasSuffix(path, ".go") {
-					files = append(files, path)
-				}
-				return nil
-			})
-			if err != nil {
-				log.Fatalf("walk %s: %v", arg, err)
+				patterns = append(patterns, "file="+abs)
+				continue
 			}
-		} else if strings.HasSuffix(arg, ".go") {
-			files = append(files, arg)
-		} else {
-			log.Fatalf("argument %s is not a Go file or directory", arg)
 		}
+		patterns = append(patterns, arg)
 	}
 
-	if len(files) == 0 {
-		log.Fatal("no Go files found")
+	configs, err := parseBuildConfigs(flagPlatforms, flagTags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flagScoreboard != "" && flagScoreboard != "markdown" && flagScoreboard != "csv" {
+		log.Fatalf("-scoreboard: unknown format %q, want markdown or csv", flagScoreboard)
 	}
 
-	// Build file patterns for packages.Load
-	var patterns []string
-	for _, f := range files {
-		abs, err := filepath.Abs(f)
+	// With -since, only sites on lines the diff touches are rewritten or reported.
+	var changed changedLines
+	if flagSince != "" {
+		changed, err = gitChangedLines(flagSince)
 		if err != nil {
-			log.Fatalf("abs %s: %v", f, err)
+			log.Fatal(err)
 		}
-		patterns = append(patterns, "file="+abs)
 	}
 
-	cfg := &packages.Config{
-		Mode:  packages.LoadSyntax, // parse + type-check + syntax
-		Dir:   ".",                 // module root
-		Tests: false,
+	if addParam {
+		os.Exit(runAddParam(configs, at, patterns))
+	}
+	if flagMigratePtrCtx {
+		os.Exit(runPointerMigration(configs, patterns))
+	}
+	if flagLintStructCtx {
+		os.Exit(runStructCtxLint(configs, patterns, changed))
+	}
+	if flagLintShadowCtx {
+		os.Exit(runShadowLint(configs, patterns, changed))
+	}
+	if flagLintCtxParams {
+		os.Exit(runCtxParamLint(configs, patterns, changed))
+	}
+	if len(flagTimeouts) > 0 {
+		os.Exit(runTimeouts(configs, patterns, changed))
 	}
 
-	pkgs, err := packages.Load(cfg, patterns...)
+	// Every build configuration is loaded and analysed on its own; a site is only
+	// rewritten when all configurations that compile its file agree on it.
+	merged := mergedResults{}
+	generated := generatedStats{}
+	failed := false
+	for _, bc := range configs {
+		if flagCache {
+			cache, err := openCache(bc)
+			if err != nil {
+				log.Fatalf("-cache: %v", err)
+			}
+			results, gen, skipped, err := loadCached(cache, bc, patterns)
+			if err != nil {
+				log.Fatalf("packages.Load (%s): %v", bc, err)
+			}
+			failed = failed || skipped > 0
+			merged.add(results)
+			generated.merge(gen)
+			continue
+		}
+		pkgs, err := loadPackages(bc.packagesConfig(), patterns...)
+		if err != nil {
+			log.Fatalf("packages.Load (%s): %v", bc, err)
+		}
+		if len(pkgs) == 0 {
+			log.Fatal("no Go packages found")
+		}
+		usable, skipped := usablePackages(pkgs, bc)
+		failed = failed || skipped > 0
+		results, gen := processPackages(usable, newFuncIndex(usable), flagJobs)
+		merged.add(results)
+		generated.merge(gen)
+	}
+
+	results := merged.results()
+	if changed != nil {
+		for i := range results {
+			results[i].findings = changed.filter(results[i].findings)
+			results[i].inconsistent = changed.filter(results[i].inconsistent)
+			results[i].unresolved = slices.DeleteFunc(results[i].unresolved, func(u unresolvedSite) bool {
+				return !changed.contains(u.pos.Filename, u.pos.Line)
+			})
+		}
+	}
+	if flagScoreboard != "" {
+		os.Exit(printScoreboard(flagScoreboard, results))
+	}
+	if check || updateBaseline {
+		if failed {
+			log.Print("some packages were not analysed; not comparing with the baseline")
+			os.Exit(1)
+		}
+		os.Exit(runBaseline(updateBaseline, flagBaseline, results))
+	}
+
+	// Recorded decisions apply to every run; -interactive asks about the other sites.
+	rv, err := newReviewer(flagDecisions, flagInteractive)
 	if err != nil {
-		log.Fatalf("packages.Load: %v", err)
+		log.Fatal(err)
 	}
-	if packages.PrintErrors(pkgs) > 0 {
-		log.Fatal("packages had errors")
+	for i := range results {
+		if results[i].findings, err = rv.review(results[i]); err != nil {
+			log.Printf("[ERROR] %s: %v", results[i].filename, err)
+			failed = true
+		}
+	}
+	if err := rv.save(flagDecisions); err != nil {
+		log.Printf("[ERROR] %v", err)
+		failed = true
 	}
 
-	// Process each file individually
+	for i := range results {
+		res := &results[i]
+		if !flagDryRun && len(res.findings) > 0 {
+			res.err = applyFindings(res.filename, res.findings)
+		}
+		for _, f := range res.findings {
+			switch {
+			case flagJSON:
+				// reported below
+			case flagDryRun && f.span:
+				fmt.Printf("[DRY] %s:%d: go statement -> span on context.WithoutCancel(%s)\n", f.pos.Filename, f.pos.Line, f.text)
+			case flagDryRun:
+				fmt.Printf("[DRY] %s:%d: context.TODO() -> %s\n", f.pos.Filename, f.pos.Line, f.text)
+			case res.err == nil && f.span:
+				fmt.Printf("✅ %s:%d: wrapped go statement in a span on context.WithoutCancel(%s)\n", f.pos.Filename, f.pos.Line, f.text)
+			case res.err == nil:
+				fmt.Printf("✅ %s:%d: replaced context.TODO() → %s\n", f.pos.Filename, f.pos.Line, f.text)
+			}
+		}
+		for _, f := range res.findings {
+			switch {
+			case f.review == "" || flagJSON:
+			case strings.HasPrefix(f.text, "context.WithoutCancel("):
+				log.Printf("[REVIEW] %s:%d: the context outlives the call (%s); detached with %s", f.pos.Filename, f.pos.Line, f.review, f.text)
+			default:
+				log.Printf("[REVIEW] %s:%d: the context outlives the call (%s); check that the goroutine may stop when %s is canceled", f.pos.Filename, f.pos.Line, f.review, f.text)
+			}
+		}
+		for _, f := range res.inconsistent {
+			log.Printf("[INCONSISTENT] %s:%d: context.TODO() does not resolve to %s in every build configuration; left unchanged", f.pos.Filename, f.pos.Line, f.text)
+		}
+		if res.err != nil {
+			log.Printf("[ERROR] %s: %v", res.filename, res.err)
+			failed = true
+		} else {
+			log.Printf("[OK] %s processed", res.filename)
+		}
+	}
+	if flagJSON {
+		if err := writeReport(os.Stdout, buildReport(results, flagDryRun)); err != nil {
+			log.Printf("[ERROR] %v", err)
+			failed = true
+		}
+	}
+	generated.print(os.Stderr)
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkFlags rejects flag combinations of which a part would be ignored: only one
+// mode runs per invocation (subcommand is the one given, if any), and some flags
+// only apply to some modes.
+func checkFlags(subcommand string) error {
+	var modes []string
+	if subcommand != "" {
+		modes = append(modes, subcommand)
+	}
+	for _, m := range []struct {
+		name string
+		set  bool
+	}{
+		{"-migrate-ptr-ctx", flagMigratePtrCtx},
+		{"-lint-struct-ctx", flagLintStructCtx},
+		{"-lint-shadow-ctx", flagLintShadowCtx},
+		{"-lint-ctx-params", flagLintCtxParams},
+		{"-timeout", len(flagTimeouts) > 0},
+		{"-scoreboard", flagScoreboard != ""},
+	} {
+		if m.set {
+			modes = append(modes, m.name)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("%s cannot be combined: they are different modes", strings.Join(modes, " and "))
+	}
+	mode := "the default mode"
+	if len(modes) == 1 {
+		mode = modes[0]
+	}
+	// the modes that analyse context.TODO() calls like the default one
+	analysing := len(modes) == 0 || mode == "check" || mode == "baseline update" || mode == "-scoreboard"
+	switch {
+	case flagFix && !flagLintStructCtx && !flagLintShadowCtx && !flagLintCtxParams:
+		return fmt.Errorf("-fix only applies to -lint-struct-ctx, -lint-shadow-ctx and -lint-ctx-params, not %s", mode)
+	case flagSince != "" && flagFix:
+		return fmt.Errorf("-since cannot be combined with -fix, which updates declarations and all their uses together")
+	case flagSince != "" && (flagMigratePtrCtx || subcommand == "add-ctx-param"):
+		return fmt.Errorf("-since cannot be combined with %s, which updates declarations and all their uses together", mode)
+	case flagInteractive && len(modes) > 0:
+		return fmt.Errorf("-interactive only applies to the default mode, not %s", mode)
+	case flagJSON && len(modes) > 0:
+		return fmt.Errorf("-json only applies to the default mode, not %s", mode)
+	case flagCache && !analysing:
+		return fmt.Errorf("-cache cannot be combined with %s, which loads every package", mode)
+	}
+	return nil
+}
+
+// fileResult is the outcome of processing a single file.
+type fileResult struct {
+	filename     string
+	findings     []finding
+	inconsistent []finding // sites that resolve differently across build configurations
+	unresolved   []unresolvedSite
+	err          error
+}
+
+// unresolvedSite is a context.TODO() call that is left in place.
+type unresolvedSite struct {
+	pos    token.Position
+	fn     string // enclosing function, as funcName; empty at package level
+	reason string
+
+	detail string // with reasonSuppressed the directive's justification, with reasonEscapes where the context escapes
+}
+
+// finding is a context.TODO() call that was (or, with -dry-run, would be) replaced,
+// a go statement wrapped in a span (see goSpan), or another rewrite of the tool.
+// Findings with empty text only carry an auxiliary edit of another finding and are
+// not reported.
+type finding struct {
+	pos    token.Position
+	text   string
+	edit   edit
+	fn     string   // for context.TODO() sites, the enclosing function as funcName
+	alts   []string // other expressions that could replace the call (-interactive)
+	review string   // why the replacement needs a second look
+
+	span  bool             // the edit wraps a go statement in a span on text
+	sites []token.Position // with span, the context.TODO() calls the edit replaces
+}
+
+// todoSites returns the context.TODO() calls f replaces.
+func (f finding) todoSites() []token.Position {
+	if f.span {
+		return f.sites
+	}
+	return []token.Position{f.pos}
+}
+
+// processPackages processes the files of pkgs on a pool of `workers` goroutines.
+// Results are collected and returned sorted by file name, findings by position,
+// so the output does not depend on scheduling. Generated files are skipped (unless
+// -include-generated) and only counted.
+func processPackages(pkgs []*packages.Package, funcs funcIndex, workers int) ([]fileResult, generatedStats) {
+	if workers < 1 {
+		workers = 1
+	}
+	// With -tests a file shows up in several package variants (p, p [p.test]);
+	// process each one once, in the first variant that contains it.
+	type pkgJob struct {
+		pkg   *packages.Package
+		files []*ast.File
+	}
+	seen := map[string]bool{}
+	generated := generatedStats{}
+	var pending []pkgJob
 	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue // synthesized test main package
+		}
+		job := pkgJob{pkg: pkg}
 		for _, file := range pkg.Syntax {
 			filename := pkg.Fset.File(file.Pos()).Name()
-			if !strings.HasSuffix(filename, ".go") {
+			if seen[filename] || !strings.HasSuffix(filename, ".go") || isExcluded(filename) {
 				continue
 			}
-			if err := processFile(pkg, file, filename); err != nil {
-				log.Printf("[ERROR] %s: %v", filename, err)
-			} else {
-				log.Printf("[OK] %s processed", filename)
+			seen[filename] = true
+			if !flagIncludeGenerated && ast.IsGenerated(file) {
+				generated.add(filename, file)
+				continue
 			}
+			job.files = append(job.files, file)
+		}
+		if len(job.files) > 0 {
+			pending = append(pending, job)
 		}
 	}
+
+	jobs := make(chan pkgJob)
+	results := make(chan fileResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				for _, file := range job.files {
+					filename := job.pkg.Fset.File(file.Pos()).Name()
+					src, _ := os.ReadFile(filename) // an unreadable file fails when it is patched
+					findings, unresolved := processFile(job.pkg, file, src, funcs)
+					results <- fileResult{filename: filename, findings: findings, unresolved: unresolved}
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, job := range pending {
+			jobs <- job
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var all []fileResult
+	for res := range results {
+		sortFindings(res.findings)
+		all = append(all, res)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].filename < all[j].filename })
+	return all, generated
 }
 
 // isContextType recognizes context.Context and pointer to it.
@@ -158,448 +493,499 @@ func isContextType(t types.Type) (ctxKind, bool) {
 	return ctxNone, false
 }
 
-// isRequestPtrType detects *http.Request
-func isRequestPtrType(t types.Type) bool {
-	ptr, ok := t.(*types.Pointer)
+// isContextTODO reports whether call is a `context.TODO()` call expression.
+func isContextTODO(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
 	if !ok {
 		return false
 	}
-	if named, ok := ptr.Elem().(*types.Named); ok {
-		if named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "net/http" && named.Obj().Name() == "Request" {
-			return true
-		}
+	identX, ok := sel.X.(*ast.Ident)
+	if !ok || identX.Name != "context" || sel.Sel == nil || sel.Sel.Name != "TODO" {
+		return false
 	}
-	return false
+	// TODO() must have zero args
+	return len(call.Args) == 0
+}
+
+// resolvesToContextPkg reports whether the `context` of a context.TODO() call refers
+// to the standard context package. Without an object for it (possible only in
+// ill-typed packages), the call is accepted unless partial is set.
+func resolvesToContextPkg(info *types.Info, call *ast.CallExpr, partial bool) bool {
+	id := call.Fun.(*ast.SelectorExpr).X.(*ast.Ident)
+	obj := info.Uses[id]
+	if obj == nil {
+		return !partial
+	}
+	pkgName, ok := obj.(*types.PkgName)
+	return ok && pkgName.Imported().Path() == "context"
 }
 
-func processFile(pkg *packages.Package, file *ast.File, filename string) error {
-	fset := pkg.Fset
-	info := pkg.TypesInfo
+// isValidType reports whether t is a known, well-formed type.
+func isValidType(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	b, ok := t.(*types.Basic)
+	return !ok || b.Kind() != types.Invalid
+}
 
-	// First pass: find goroutine skips:
-	// - anonymous func literals in `go func(...) { ... }(...)` (skip their body only)
-	// - resolved functions invoked via `go someFunc(...)` or `go pkg.Func(...)` (skip whole target function)
-	skipRanges := []skipInterval{}
-	skipFuncs := map[*types.Func]bool{}
+// replacement records a context.TODO() call and the source text that replaces it.
+type replacement struct {
+	call *ast.CallExpr
+	text string
+	decl *ast.FuncDecl // enclosing function, nil at package level
+	alts []string      // other context sources in scope, then context.WithoutCancel(text)
 
-	ast.Inspect(file, func(n ast.Node) bool {
-		gs, ok := n.(*ast.GoStmt)
-		if !ok {
-			return true
+	escape string // where the callee keeps the context, see funcIndex.escape
+
+	span *goSpan // set if call is that of a go statement the replacement wraps; text is span.source
+}
+
+// edit replaces the source bytes in [start, end) with text.
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// editSet collects edits across files for the modes that change declarations and
+// their uses together (-migrate-ptr-ctx, -lint-struct-ctx -fix).
+type editSet struct {
+	fset     *token.FileSet
+	findings map[string][]finding // by filename
+	ctxName  map[string]string    // filename -> name the context package is imported as
+	removed  map[string][]edit    // deletions planned so far, by filename
+}
+
+func newEditSet(fset *token.FileSet) editSet {
+	return editSet{fset: fset, findings: map[string][]finding{}, ctxName: map[string]string{}, removed: map[string][]edit{}}
+}
+
+// note records an edit together with the message reported for it.
+func (es *editSet) note(at token.Pos, text string, e edit) {
+	p := es.fset.Position(at)
+	es.findings[p.Filename] = append(es.findings[p.Filename], finding{pos: p, text: text, edit: e})
+}
+
+func (es *editSet) offset(p token.Pos) int {
+	return es.fset.Position(p).Offset
+}
+
+// contextName returns the name file imports "context" under, adding the import if needed.
+func (es *editSet) contextName(file *ast.File) string {
+	filename := es.fset.File(file.Pos()).Name()
+	if name, ok := es.ctxName[filename]; ok {
+		return name
+	}
+	e, name, add := addImportEdit(es.fset, file, "context")
+	if add {
+		es.note(file.Name.Pos(), `import "context"`, e)
+	}
+	es.ctxName[filename] = name
+	return name
+}
+
+// ownLines returns the deletion of the whole lines from..to span if nothing else
+// is on them: prev ends on an earlier line and next starts on a later one.
+func (es *editSet) ownLines(prev, from, to, next token.Pos) (edit, bool) {
+	tf := es.fset.File(from)
+	first, last := tf.Line(from), tf.Line(to)
+	if tf.Line(prev) >= first || tf.Line(next) <= last {
+		return edit{}, false
+	}
+	return edit{start: tf.Offset(tf.LineStart(first)), end: tf.Offset(tf.LineStart(last + 1))}, true
+}
+
+// removeImports drops the context import from files whose only uses of it were
+// deleted (see removed).
+func (es *editSet) removeImports(units []migrationUnit) {
+	for _, u := range units {
+		filename := es.fset.File(u.file.Pos()).Name()
+		if len(es.removed[filename]) == 0 {
+			continue
+		}
+		if _, added := es.ctxName[filename]; added {
+			continue // the fix added uses of context to this file
 		}
-		call := gs.Call
-		// anonymous literal
-		if funLit, ok := call.Fun.(*ast.FuncLit); ok {
-			if funLit.Body != nil {
-				skipRanges = append(skipRanges, skipInterval{start: funLit.Body.Lbrace, end: funLit.Body.Rbrace})
+		used := false
+		for id, obj := range u.pkg.TypesInfo.Uses {
+			pn, ok := obj.(*types.PkgName)
+			if !ok || pn.Imported().Path() != "context" || es.fset.File(id.Pos()) != es.fset.File(u.file.Pos()) {
+				continue
 			}
-			return true
+			off := es.offset(id.Pos())
+			inside := false
+			for _, e := range es.removed[filename] {
+				inside = inside || (off >= e.start && off < e.end)
+			}
+			used = used || !inside
 		}
-		// named or selector: try resolve the function object and mark skipFuncs
-		switch fn := call.Fun.(type) {
-		case *ast.Ident:
-			if obj := info.Uses[fn]; obj != nil {
-				if tf, ok := obj.(*types.Func); ok {
-					skipFuncs[tf] = true
-				}
+		if used {
+			continue
+		}
+		for _, decl := range u.file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.IMPORT {
+				continue
 			}
-		case *ast.SelectorExpr:
-			// selector.Sel is an Ident; Try to look up Uses for the Sel
-			if sel := fn.Sel; sel != nil {
-				if obj := info.Uses[sel]; obj != nil {
-					if tf, ok := obj.(*types.Func); ok {
-						skipFuncs[tf] = true
+			for i, spec := range gd.Specs {
+				is := spec.(*ast.ImportSpec)
+				if is.Path.Value != `"context"` {
+					continue
+				}
+				var e edit
+				ok := false
+				if len(gd.Specs) == 1 {
+					next := token.Pos(es.fset.File(gd.Pos()).Base() + es.fset.File(gd.Pos()).Size())
+					for j, d := range u.file.Decls {
+						if d == decl && j+1 < len(u.file.Decls) {
+							next = u.file.Decls[j+1].Pos()
+						}
 					}
+					e, ok = es.ownLines(u.file.Name.End(), gd.Pos(), gd.End(), next)
+				} else {
+					prev, next := gd.Lparen, gd.Rparen
+					if i > 0 {
+						prev = gd.Specs[i-1].End()
+					}
+					if i+1 < len(gd.Specs) {
+						next = gd.Specs[i+1].Pos()
+					}
+					e, ok = es.ownLines(prev, is.Pos(), is.End(), next)
+				}
+				if ok {
+					es.note(is.Pos(), `removed import "context"`, e)
 				}
 			}
 		}
-		return true
-	})
+	}
+}
 
-	// Helper: test if pos lies inside any skipRange
-	insideSkipRange := func(pos token.Pos) bool {
-		for _, r := range skipRanges {
-			if pos >= r.start && pos <= r.end {
-				return true
-			}
-		}
-		return false
+// results returns the collected edits per file, sorted by file name and position.
+func (es *editSet) results() []fileResult {
+	var results []fileResult
+	for filename, fs := range es.findings {
+		sortFindings(fs)
+		results = append(results, fileResult{filename: filename, findings: fs})
 	}
+	sort.Slice(results, func(i, j int) bool { return results[i].filename < results[j].filename })
+	return results
+}
 
-	// frame stack for scoping; each frame inherits parent's values on push
-	var frameStack []scopeFrame
-	pushFrame := func(copyFrom *scopeFrame) {
-		if copyFrom == nil {
-			frameStack = append(frameStack, scopeFrame{})
-			return
+// processFile analyses one file and returns its replaceable context.TODO() calls,
+// each with the source edit that rewrites it, and the calls it leaves in place.
+//
+// A context that the callee keeps beyond the call (see funcIndex.escape) would be
+// canceled under it once the caller returns, so the replacement detaches it with
+// context.WithoutCancel and is flagged for review; before Go 1.21 the call is left
+// in place. One kept by a goroutine is only flagged: the goroutine may be meant to
+// stop with the call.
+//
+// src is the source of file, nil if it could not be read: go statements are then
+// left as they are.
+func processFile(pkg *packages.Package, file *ast.File, src []byte, funcs funcIndex) ([]finding, []unresolvedSite) {
+	reps, left := findSites(pkg.Fset, pkg.TypesInfo, file, src, pkg.IllTyped)
+	args := argCalls(file)
+	kept := reps[:0]
+	for _, rep := range reps {
+		detach := false
+		if arg, ok := args[rep.call]; ok {
+			rep.escape, detach = funcs.escape(pkg.Fset, pkg.TypesInfo, arg.call, arg.index)
+		}
+		switch {
+		case rep.escape == "" || !detach:
+		case withoutCancelAvailable(pkg.TypesInfo, file):
+			detached := "context.WithoutCancel(" + rep.text + ")"
+			rep.alts = append([]string{rep.text}, slices.DeleteFunc(rep.alts, func(alt string) bool { return alt == detached })...)
+			rep.text = detached
+		default:
+			left = append(left, leftCall{call: rep.call, decl: rep.decl, reason: reasonEscapes, detail: rep.escape})
+			continue
 		}
-		frameStack = append(frameStack, *copyFrom)
+		kept = append(kept, rep)
 	}
-	popFrame := func() {
-		if len(frameStack) == 0 {
-			return
+	reps = kept
+	edits := replacementEdits(pkg.Fset, file, reps)
+	findings := make([]finding, 0, len(reps))
+	for i, rep := range reps {
+		f := finding{pos: pkg.Fset.Position(rep.call.Pos()), text: rep.text, edit: edits[i], alts: rep.alts, review: rep.escape}
+		if rep.span != nil {
+			f.pos, f.span = pkg.Fset.Position(rep.span.stmt.Pos()), true
+			for _, r := range rep.span.reps {
+				f.sites = append(f.sites, pkg.Fset.Position(r.call.Pos()))
+			}
+		}
+		if rep.decl != nil {
+			f.fn = funcName(pkg.Name, rep.decl)
 		}
-		frameStack = frameStack[:len(frameStack)-1]
+		findings = append(findings, f)
 	}
-	currentFrame := func() *scopeFrame {
-		if len(frameStack) == 0 {
-			return nil
+	var unresolved []unresolvedSite
+	for _, l := range left {
+		site := unresolvedSite{pos: pkg.Fset.Position(l.call.Pos()), reason: l.reason, detail: l.detail}
+		if l.decl != nil {
+			site.fn = funcName(pkg.Name, l.decl)
 		}
-		return &frameStack[len(frameStack)-1]
+		unresolved = append(unresolved, site)
 	}
+	return findings, unresolved
+}
 
-	// funcStack to know if current function is one that should be skipped entirely (because it's invoked by `go` elsewhere)
-	type funcCtx struct {
-		fnObj     *types.Func
-		skipWhole bool
+// funcName names decl of package pkg in reports: "svc.Start", "svc.(*Server).Start".
+func funcName(pkg string, decl *ast.FuncDecl) string {
+	name := decl.Name.Name
+	if decl.Recv != nil && len(decl.Recv.List) > 0 {
+		recv := types.ExprString(decl.Recv.List[0].Type)
+		if strings.HasPrefix(recv, "*") {
+			recv = "(" + recv + ")"
+		}
+		name = recv + "." + name
 	}
-	var funcStack []funcCtx
-
-	// We'll collect whether we made changes
-	var replaced bool
+	return pkg + "." + name
+}
 
-	// Use astutil.Apply to walk and potentially replace nodes
-	newFile := astutil.Apply(file,
-		// pre
-		func(c *astutil.Cursor) bool {
-			n := c.Node()
-			if n == nil {
-				return true
-			}
+// applyFindings patches filename with the edits of findings.
+func applyFindings(filename string, findings []finding) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	edits := make([]edit, 0, len(findings))
+	for _, f := range findings {
+		edits = append(edits, f.edit)
+	}
+	out, err := applyEdits(src, edits)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filename, out); err != nil {
+		return fmt.Errorf("writeFile: %w", err)
+	}
+	return nil
+}
 
-			switch node := n.(type) {
-			case *ast.FuncDecl:
-				// entering a function decl: push new frame (inheriting nothing)
-				pushFrame(nil)
-
-				// determine if this function is one of the skipFuncs
-				var fnObj *types.Func
-				if node.Name != nil {
-					if obj := info.Defs[node.Name]; obj != nil {
-						if f, ok := obj.(*types.Func); ok {
-							fnObj = f
-						}
-					}
-				}
-				skip := fnObj != nil && skipFuncs[fnObj]
-				funcStack = append(funcStack, funcCtx{fnObj: fnObj, skipWhole: skip})
-
-				// Inspect params to fill baseline availability
-				if node.Type != nil && node.Type.Params != nil {
-					fr := currentFrame()
-					for _, fld := range node.Type.Params.List {
-						for _, nm := range fld.Names {
-							if nm == nil {
-								continue
-							}
-							// try to get the type from info.Defs (for param id) or Types map
-							var t types.Type
-							if obj := info.Defs[nm]; obj != nil {
-								t = obj.Type()
-							} else if tv := info.Types[nm]; tv.Type != nil {
-								t = tv.Type
-							}
-							if t == nil {
-								// sometimes the type is on the field.Type (use typeOf expression)
-								if fld.Type != nil {
-									if tv := info.TypeOf(fld.Type); tv != nil {
-										t = tv
-									}
-								}
-							}
-							if t == nil {
-								continue
-							}
-							if nm.Name == "ctx" {
-								if kind, ok := isContextType(t); ok {
-									fr.ctxKind = kind
-									fr.ctxAvailPos = nm.Pos()
-								}
-							} else if nm.Name == "r" {
-								if isRequestPtrType(t) {
-									fr.rPresent = true
-									fr.rAvailPos = nm.Pos()
-								}
-							}
-						}
-					}
-				}
-				return true
+// findReplacements walks file and decides, for every context.TODO() call, which
+// in-scope context source replaces it. Calls with nothing in scope are omitted.
+//
+// partial marks type information from an ill-typed package: a site is then only
+// rewritten if `context` resolves to the context package and no ctx/r declaration
+// in scope failed to type-check, so the choice cannot hinge on the broken parts.
+//
+// Go statements using the context in scope are wrapped in a span of their own (see
+// goSpan) if src, the source of file, is given.
+func findReplacements(fset *token.FileSet, info *types.Info, file *ast.File, src []byte, partial bool) []replacement {
+	reps, _ := findSites(fset, info, file, src, partial)
+	return reps
+}
 
-			case *ast.FuncLit:
-				// entering a function literal: push new frame (inheriting nothing)
-				pushFrame(nil)
-
-				// func literal params
-				if node.Type != nil && node.Type.Params != nil {
-					fr := currentFrame()
-					for _, fld := range node.Type.Params.List {
-						for _, nm := range fld.Names {
-							if nm == nil {
-								continue
-							}
-							var t types.Type
-							if obj := info.Defs[nm]; obj != nil {
-								t = obj.Type()
-							} else if tv := info.Types[nm]; tv.Type != nil {
-								t = tv.Type
-							}
-							if t == nil && fld.Type != nil {
-								if tv := info.TypeOf(fld.Type); tv != nil {
-									t = tv
-								}
-							}
-							if t == nil {
-								continue
-							}
-							if nm.Name == "ctx" {
-								if kind, ok := isContextType(t); ok {
-									fr.ctxKind = kind
-									fr.ctxAvailPos = nm.Pos()
-								}
-							} else if nm.Name == "r" {
-								if isRequestPtrType(t) {
-									fr.rPresent = true
-									fr.rAvailPos = nm.Pos()
-								}
-							}
-						}
-					}
-				}
-				// For func literals, we can't easily map to a types.Func object for skipWhole detection.
-				// However, we already recorded anonymous goroutine bodies as skipRanges earlier.
-				funcStack = append(funcStack, funcCtx{fnObj: nil, skipWhole: false})
-				return true
+// Reasons a context.TODO() call is left in place.
+const (
+	reasonNoContext  = "no context in scope"
+	reasonGoroutine  = "runs in a goroutine"
+	reasonSuppressed = "suppressed"
+	reasonComment    = "a line comment in the call contains */"
+)
 
-			case *ast.BlockStmt:
-				// push a child frame that inherits the parent frame
-				var copyFrom *scopeFrame
-				if cur := currentFrame(); cur != nil {
-					copyFrom = cur
-				}
-				pushFrame(copyFrom)
-				return true
+// leftCall is a context.TODO() call that is not rewritten.
+type leftCall struct {
+	call   *ast.CallExpr
+	decl   *ast.FuncDecl // enclosing function, nil at package level
+	reason string
+	detail string // the justification of a suppression, where a context escapes
+}
 
-			case *ast.AssignStmt:
-				// handle `:=` new declarations for ctx and r
-				if node.Tok == token.DEFINE {
-					for _, lhs := range node.Lhs {
-						id, ok := lhs.(*ast.Ident)
-						if !ok || id == nil {
-							continue
-						}
-						// Try to get the declared object's type via info.Defs (should be present for :=)
-						var t types.Type
-						if obj := info.Defs[id]; obj != nil {
-							t = obj.Type()
-						} else if tv := info.Types[id]; tv.Type != nil {
-							t = tv.Type
-						}
-						// as fallback, attempt to get type from the corresponding RHS expr (best-effort)
-						if t == nil {
-							// find index of id in Lhs to map rhs
-							for idx, lhsExpr := range node.Lhs {
-								if lhsExpr == id && idx < len(node.Rhs) {
-									if rhsT := info.TypeOf(node.Rhs[idx]); rhsT != nil {
-										t = rhsT
-									}
-									break
-								}
-							}
-						}
-						if t == nil {
-							continue
-						}
-						fr := currentFrame()
-						if id.Name == "ctx" {
-							if kind, ok := isContextType(t); ok {
-								fr.ctxKind = kind
-								fr.ctxAvailPos = id.Pos()
-							}
-						} else if id.Name == "r" {
-							if isRequestPtrType(t) {
-								fr.rPresent = true
-								fr.rAvailPos = id.Pos()
-							}
-						}
-					}
+// findSites is findReplacements that also returns the context.TODO() calls it
+// leaves in place, including those a //ctxast:ignore directive suppresses.
+func findSites(fset *token.FileSet, info *types.Info, file *ast.File, src []byte, partial bool) ([]replacement, []leftCall) {
+	var reps []replacement
+	var left []leftCall
+	withoutCancel := withoutCancelAvailable(info, file)
+	sup := fileSuppressions(fset, file)
+	goStmts := map[*ast.CallExpr]*ast.GoStmt{}
+	if src != nil && withoutCancel && !flagNoGoroutines {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if gs, ok := n.(*ast.GoStmt); ok {
+				goStmts[gs.Call] = gs
+			}
+			return true
+		})
+	}
+	var spans []*goSpan
+	spanAt := func(pos token.Pos) *goSpan {
+		for _, sp := range spans {
+			if pos >= sp.stmt.Pos() && pos < sp.stmt.End() {
+				return sp
+			}
+		}
+		return nil
+	}
+	walkScopes(info, file, partial, func(call *ast.CallExpr, site callSite) bool {
+		if gs, ok := goStmts[call]; ok && !site.skip && spanAt(call.Pos()) == nil {
+			if sp := newGoSpan(fset, info, src, gs, site); sp != nil {
+				spans = append(spans, sp)
+			}
+		}
+		if !isContextTODO(call) || !resolvesToContextPkg(info, call, partial) {
+			return true
+		}
+		if why, ok := sup.suppressed(call.Pos()); ok {
+			left = append(left, leftCall{call: call, decl: site.decl, reason: reasonSuppressed, detail: why})
+			return false
+		}
+		sp := spanAt(call.Pos())
+		if sp != nil && sp.detaches(site) {
+			site.ctxExpr, site.alternatives = goSpanCtx, nil
+		}
+		switch {
+		case site.ctxExpr == "":
+			left = append(left, leftCall{call: call, decl: site.decl, reason: reasonNoContext})
+		case site.skip:
+			left = append(left, leftCall{call: call, decl: site.decl, reason: reasonGoroutine})
+		case unmovableComment(file, call):
+			left = append(left, leftCall{call: call, decl: site.decl, reason: reasonComment})
+		default:
+			// Record the replacement; the source is patched later by byte offsets.
+			rep := replacement{call: call, text: site.ctxExpr, decl: site.decl, alts: site.alternatives}
+			switch {
+			case sp == nil:
+				if withoutCancel {
+					rep.alts = append(rep.alts, "context.WithoutCancel("+site.ctxExpr+")")
 				}
-				return true
+				reps = append(reps, rep)
+			case rep.text == goSpanCtx:
+				sp.detached++
+				fallthrough
+			default:
+				sp.reps = append(sp.reps, rep)
+			}
+		}
+		// do not visit children of replaced node
+		return false
+	})
+	for _, sp := range spans {
+		if !sp.active() {
+			reps = append(reps, sp.reps...)
+			continue
+		}
+		sp.render(fset, file, src)
+		reps = append(reps, replacement{call: sp.stmt.Call, text: sp.source, decl: sp.decl, span: sp})
+	}
+	sort.SliceStable(reps, func(i, j int) bool { return reps[i].call.Pos() < reps[j].call.Pos() })
+	return reps, left
+}
 
-			case *ast.ValueSpec:
-				// var declarations: var ctx context.Context or var ctx = something
-				for _, id := range node.Names {
-					if id == nil {
-						continue
-					}
-					if id.Name != "ctx" && id.Name != "r" {
-						continue
-					}
-					var t types.Type
-					if obj := info.Defs[id]; obj != nil {
-						t = obj.Type()
-					} else if node.Type != nil {
-						if tv := info.TypeOf(node.Type); tv != nil {
-							t = tv
-						}
-					} else {
-						// try initializer
-						for _, val := range node.Values {
-							if tv := info.TypeOf(val); tv != nil {
-								t = tv
-								break
-							}
-						}
-					}
-					if t == nil {
-						continue
-					}
-					fr := currentFrame()
-					if id.Name == "ctx" {
-						if kind, ok := isContextType(t); ok {
-							fr.ctxKind = kind
-							fr.ctxAvailPos = id.Pos()
-						}
-					} else if id.Name == "r" {
-						if isRequestPtrType(t) {
-							fr.rPresent = true
-							fr.rAvailPos = id.Pos()
-						}
-					}
-				}
+// unmovableComment reports whether call contains a line comment that cannot become
+// a block comment because it contains "*/". Keeping it as a line comment would need
+// a newline after the replacement, where one would end the statement.
+func unmovableComment(file *ast.File, call *ast.CallExpr) bool {
+	for _, cg := range file.Comments {
+		if cg.Pos() < call.Pos() || cg.End() > call.End() {
+			continue
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, "//") && strings.Contains(c.Text, "*/") {
 				return true
+			}
+		}
+	}
+	return false
+}
 
-			case *ast.CallExpr:
-				// We only rewrite context.TODO() call expressions.
-				// But first -- skip cases:
-				//  - if the containing function is flagged skipWhole (because it is invoked via `go target(...)`)
-				//  - if this call is inside an anonymous goroutine body and -no-goroutines is set (skipRanges)
-				// Determine if current function is skipWhole
-				if len(funcStack) > 0 && funcStack[len(funcStack)-1].skipWhole {
-					return true
-				}
-				// If -no-goroutines passed, skip any call that is inside skipRanges
-				if flagNoGoroutines {
-					if insideSkipRange(node.Lparen) {
-						return true
-					}
-				}
-				// Check selector expression: context.TODO
-				sel, ok := node.Fun.(*ast.SelectorExpr)
-				if !ok {
-					return true
-				}
-				identX, ok := sel.X.(*ast.Ident)
-				if !ok || identX.Name != "context" || sel.Sel == nil || sel.Sel.Name != "TODO" {
-					return true
-				}
-				// TODO() must have zero args
-				if len(node.Args) != 0 {
-					return true
-				}
-				// Now find current frame and decide replacement
-				fr := currentFrame()
-				if fr == nil {
-					return true
-				}
-				pos := node.Pos()
-				// Decide replacement in priority:
-				// 1) ctx (if ctxKind != ctxNone and node pos >= ctxAvailPos)
-				// 2) *ctx if pointer
-				// 3) r.Context() (if rPresent and pos >= rAvailPos)
-				var repl ast.Expr
-				var replStr string
-				if fr.ctxKind != ctxNone && pos >= fr.ctxAvailPos {
-					if fr.ctxKind == ctxValue {
-						repl = ast.NewIdent("ctx")
-						replStr = "ctx"
-					} else {
-						// *ctx: represent as '(*ctx)'? In expressions `*ctx` is unary; we'll use unary expr.
-						repl = &ast.UnaryExpr{Op: token.MUL, X: ast.NewIdent("ctx")}
-						replStr = "*ctx"
-					}
-				} else if fr.rPresent && pos >= fr.rAvailPos {
-					repl = &ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   ast.NewIdent("r"),
-							Sel: ast.NewIdent("Context"),
-						},
-					}
-					replStr = "r.Context()"
-				} else {
-					// nothing in scope -> leave as-is
-					return true
-				}
-
-				// Replace node
-				cReplace(c, repl)
-				p := fset.Position(pos)
-				if flagDryRun {
-					fmt.Printf("[DRY] %s:%d: context.TODO() -> %s\n", p.Filename, p.Line, replStr)
-				} else {
-					fmt.Printf("✅ %s:%d: replaced context.TODO() → %s\n", p.Filename, p.Line, replStr)
-				}
-				replaced = true
-
-				// do not visit children of replaced node
-				return false
+// replacementEdits converts replacements into byte-range edits of the original source.
+// Comments inside a replaced call are carried over after the replacement text; line
+// comments are rewritten as block comments so they cannot swallow the rest of the line
+// (findSites leaves the calls whose line comments would end such a block early).
+func replacementEdits(fset *token.FileSet, file *ast.File, reps []replacement) []edit {
+	edits := make([]edit, 0, len(reps))
+	for _, rep := range reps {
+		if rep.span != nil {
+			edits = append(edits, edit{
+				start: fset.Position(rep.call.Pos()).Offset,
+				end:   fset.Position(rep.call.End()).Offset,
+				text:  rep.span.text,
+			})
+			continue
+		}
+		text := rep.text
+		for _, cg := range file.Comments {
+			if cg.Pos() < rep.call.Pos() || cg.End() > rep.call.End() {
+				continue
 			}
-			return true
-		},
-		// post
-		func(c *astutil.Cursor) bool {
-			switch c.Node().(type) {
-			case *ast.BlockStmt:
-				popFrame()
-			case *ast.FuncDecl:
-				// pop funcStack and frame
-				if len(funcStack) > 0 {
-					funcStack = funcStack[:len(funcStack)-1]
-				}
-				popFrame()
-			case *ast.FuncLit:
-				if len(funcStack) > 0 {
-					funcStack = funcStack[:len(funcStack)-1]
+			for _, c := range cg.List {
+				if strings.HasPrefix(c.Text, "//") {
+					text += " /*" + strings.TrimRight(c.Text[2:], " \t") + " */"
+				} else {
+					text += " " + c.Text
 				}
-				popFrame()
 			}
-			return true
+		}
+		edits = append(edits, edit{
+			start: fset.Position(rep.call.Pos()).Offset,
+			end:   fset.Position(rep.call.End()).Offset,
+			text:  text,
 		})
-
-	// newFile is an *ast.File (astutil.Apply returns interface{}); we don't need the returned value beyond writing
-	if newFile == nil {
-		return fmt.Errorf("internal rewrite returned nil AST")
-	}
-
-	if !replaced {
-		// nothing to change
-		return nil
 	}
+	return edits
+}
 
-	// Write AST back to file preserving comments + layout (unless dry-run)
-	if flagDryRun {
-		return nil
-	}
-	if err := writeFile(fset, file, filename); err != nil {
-		return fmt.Errorf("writeFile: %w", err)
+// applyEdits patches src with edits. Bytes outside the edited spans are copied verbatim,
+// so untouched code keeps its exact formatting.
+func applyEdits(src []byte, edits []edit) ([]byte, error) {
+	// an insertion goes before a replacement starting at the same offset
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		return edits[i].end < edits[j].end
+	})
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.start < last || e.end < e.start || e.end > len(src) {
+			return nil, fmt.Errorf("invalid or overlapping edit at offset %d", e.start)
+		}
+		out.Write(src[last:e.start])
+		out.WriteString(e.text)
+		last = e.end
 	}
-	return nil
+	out.Write(src[last:])
+	return out.Bytes(), nil
 }
 
-// cReplace centralizes cursor.Replace (wrapped to satisfy type expectations)
-func cReplace(c *astutil.Cursor, repl ast.Expr) *astutil.Cursor {
-	c.Replace(repl)
-	return c
+// writeFile replaces path's content, keeping its permissions.
+func writeFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, info.Mode().Perm())
 }
 
-// writeFile preserves comments + formatting
-func writeFile(fset *token.FileSet, f *ast.File, path string) error {
-	fOut, err := os.Create(path)
+// RewriteContent rewrites the context.TODO() calls of a single Go source file and
+// returns the patched source. The file is type-checked on its own; type errors (e.g.
+// references to undeclared helpers) are tolerated as long as the file parses.
+func RewriteContent(src string) (string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "input.go", src, parser.ParseComments)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer fOut.Close()
+	info := &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Defs:  map[*ast.Ident]types.Object{},
+		Uses:  map[*ast.Ident]types.Object{},
+	}
+	typeErrors := 0
+	conf := types.Config{
+		Importer: importer.Default(),
+		Error:    func(error) { typeErrors++ }, // keep going; partial type info is fine
+	}
+	_, _ = conf.Check(file.Name.Name, fset, []*ast.File{file}, info)
 
-	cfg := &printer.Config{Mode: printer.TabIndent | printer.UseSpaces, Tabwidth: 8}
-	return cfg.Fprint(fOut, fset, f)
+	reps := findReplacements(fset, info, file, []byte(src), typeErrors > 0)
+	out, err := applyEdits([]byte(src), replacementEdits(fset, file, reps))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"go/token"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -1166,6 +1167,71 @@ func main() {
 `,
 	},
 
+	// The variables a declaration declares are only in scope after it
+	{
+		name: "ctx declared from a nested TODO",
+		input: `
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	do(ctx)
+}
+
+func value(k, v any) {
+	ctx := context.WithValue(context.TODO(), k, v)
+	do(ctx)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(context.TODO(), "k", "v")
+	do(ctx)
+}
+
+func reassign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 0)
+	defer cancel()
+	do(ctx)
+}
+`,
+		expected: `
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	do(ctx)
+}
+
+func value(k, v any) {
+	ctx := context.WithValue(context.TODO(), k, v)
+	do(ctx)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), "k", "v")
+	do(ctx)
+}
+
+func reassign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	do(ctx)
+}
+`,
+	},
+
 	// Pointer local ctx
 	{
 		name: "pointer ctx local",
@@ -1483,3 +1549,229 @@ func normalizeCode(code string) string {
 	}
 	return strings.Join(lines, "\n")
 }
+
+func TestRewriteContentPreservesFormatting(t *testing.T) {
+	input := `package main
+
+import "context"
+
+func main(ctx context.Context) {
+	x  :=   1 // not gofmt-clean, must stay as-is
+	doSomething(context.TODO( /* why */ ), x)
+	doSomething(context.TODO( // line comment
+	), x)
+	doSomething(context.TODO( // ends a block: */
+	), x)
+}
+`
+	expected := `package main
+
+import "context"
+
+func main(ctx context.Context) {
+	x  :=   1 // not gofmt-clean, must stay as-is
+	doSomething(ctx /* why */, x)
+	doSomething(ctx /* line comment */, x)
+	doSomething(context.TODO( // ends a block: */
+	), x)
+}
+`
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRewriteContentTestContext(t *testing.T) {
+	input := `package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFoo(t *testing.T) {
+	do(context.TODO())
+	t.Run("sub", func(t *testing.T) {
+		do(context.TODO())
+	})
+}
+
+func BenchmarkFoo(b *testing.B) {
+	do(context.TODO())
+}
+
+func helper(ctx context.Context, t *testing.T) {
+	do(context.TODO())
+}
+`
+	expected := `package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFoo(t *testing.T) {
+	do(t.Context())
+	t.Run("sub", func(t *testing.T) {
+		do(t.Context())
+	})
+}
+
+func BenchmarkFoo(b *testing.B) {
+	do(b.Context())
+}
+
+func helper(ctx context.Context, t *testing.T) {
+	do(ctx)
+}
+`
+	optOut, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.NotContains(t, optOut, ".Context()", "t.Context() must be opt-in")
+
+	flagTestContext = true
+	defer func() { flagTestContext = false }()
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRewriteContentPartialTypes(t *testing.T) {
+	input := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func ok(ctx context.Context) {
+	undefinedHelper(context.TODO())
+}
+
+func broken(r *http.Request) {
+	do(context.TODO())
+	ctx := undefinedFactory()
+	do(context.TODO())
+}
+`
+	expected := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func ok(ctx context.Context) {
+	undefinedHelper(ctx)
+}
+
+func broken(r *http.Request) {
+	do(r.Context())
+	ctx := undefinedFactory()
+	do(context.TODO())
+}
+`
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRewriteContentOtherContextPackage(t *testing.T) {
+	input := `package main
+
+import context "example.com/notcontext"
+
+func f(ctx context.Context) {
+	do(context.TODO())
+}
+`
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, input, actual)
+}
+
+func TestProcessPackagesSorted(t *testing.T) {
+	dir := t.TempDir()
+	src := `package %s
+
+import "context"
+
+func F(ctx context.Context) {
+	use(context.TODO())
+	use(context.TODO())
+}
+
+func G(ctx context.Context) {
+	use(context.TODO())
+}
+
+func use(context.Context) {}
+`
+	srcs := map[string]map[string]string{}
+	var want []string
+	for _, name := range []string{"a/x.go", "b/y.go", "c/z.go"} {
+		filename := filepath.Join(dir, name)
+		pkg := filepath.Dir(name)
+		content := strings.Replace(src, "%s", pkg, 1)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+		srcs["example.com/"+pkg] = map[string]string{filename: content}
+		want = append(want, filename)
+	}
+	fset := token.NewFileSet()
+	// listed in reverse, so the workers finish them out of order
+	pkgs := testPackages(t, fset, []string{"example.com/c", "example.com/b", "example.com/a"}, srcs)
+
+	results, _ := processPackages(pkgs, newFuncIndex(pkgs), 3)
+	var got []string
+	for _, res := range results {
+		got = append(got, res.filename)
+		var lines []int
+		for _, f := range res.findings {
+			lines = append(lines, f.pos.Line)
+		}
+		assert.Equal(t, []int{6, 7, 11}, lines, res.filename)
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestCheckFlags(t *testing.T) {
+	reset := func() {
+		flagMigratePtrCtx, flagLintShadowCtx, flagFix, flagSince, flagScoreboard = false, false, false, "", ""
+		flagInteractive, flagJSON = false, false
+		flagTimeouts, flagCache = nil, false
+	}
+	defer reset()
+	for _, c := range []struct {
+		name       string
+		subcommand string
+		set        func()
+		err        string
+	}{
+		{"default", "", func() {}, ""},
+		{"lint with fix", "", func() { flagLintShadowCtx, flagFix = true, true }, ""},
+		{"lint since", "", func() { flagLintShadowCtx, flagSince = true, "main" }, ""},
+		{"two modes", "", func() { flagMigratePtrCtx, flagScoreboard = true, "csv" }, "-migrate-ptr-ctx and -scoreboard cannot be combined: they are different modes"},
+		{"subcommand and mode", "check", func() { flagLintShadowCtx = true }, "check and -lint-shadow-ctx cannot be combined: they are different modes"},
+		{"fix without lint", "", func() { flagMigratePtrCtx, flagFix = true, true }, "-fix only applies to -lint-struct-ctx, -lint-shadow-ctx and -lint-ctx-params, not -migrate-ptr-ctx"},
+		{"since with fix", "", func() { flagLintShadowCtx, flagFix, flagSince = true, true, "main" }, "-since cannot be combined with -fix, which updates declarations and all their uses together"},
+		{"since with add-ctx-param", "add-ctx-param", func() { flagSince = "main" }, "-since cannot be combined with add-ctx-param, which updates declarations and all their uses together"},
+		{"interactive check", "check", func() { flagInteractive = true }, "-interactive only applies to the default mode, not check"},
+		{"json lint", "", func() { flagLintShadowCtx, flagJSON = true, true }, "-json only applies to the default mode, not -lint-shadow-ctx"},
+		{"timeout and mode", "", func() { flagMigratePtrCtx, flagTimeouts = true, stringList{"f=1s"} }, "-migrate-ptr-ctx and -timeout cannot be combined: they are different modes"},
+		{"cached scoreboard", "", func() { flagScoreboard, flagCache = "csv", true }, ""},
+		{"cached lint", "", func() { flagLintShadowCtx, flagCache = true, true }, "-cache cannot be combined with -lint-shadow-ctx, which loads every package"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			reset()
+			c.set()
+			err := checkFlags(c.subcommand)
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, c.err)
+			}
+		})
+	}
+}
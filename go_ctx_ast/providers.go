@@ -0,0 +1,197 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// contextProvider describes a type whose values carry a context, and the expression
+// that extracts it. expr is a format string; %s is the variable name.
+//
+// To support another framework, add an entry here; the scope walker matches
+// declarations against this table and needs no changes.
+type contextProvider struct {
+	pkgPath  string
+	typeName string
+	pointer  bool   // the variable has type *pkgPath.typeName
+	expr     string // e.g. "%s.Context()"
+
+	// firstParam restricts the provider to a function's first parameter.
+	firstParam bool
+	// testOnly providers are only used with -test-context (Go 1.24+ files).
+	testOnly bool
+}
+
+// contextProviders is ordered by priority: when several are in scope the earliest
+// entry wins. A variable named ctx of type context.Context always beats them all.
+var contextProviders = []contextProvider{
+	// gRPC and other functions that already take a context first, whatever its name
+	{pkgPath: "context", typeName: "Context", expr: "%s", firstParam: true},
+	// net/http handlers: func(w http.ResponseWriter, r *http.Request)
+	{pkgPath: "net/http", typeName: "Request", pointer: true, expr: "%s.Context()"},
+	// gin: func(c *gin.Context)
+	{pkgPath: "github.com/gin-gonic/gin", typeName: "Context", pointer: true, expr: "%s.Request.Context()"},
+	// echo: func(c echo.Context) error
+	{pkgPath: "github.com/labstack/echo/v4", typeName: "Context", expr: "%s.Request().Context()"},
+	{pkgPath: "github.com/labstack/echo", typeName: "Context", expr: "%s.Request().Context()"},
+	// fiber: func(c *fiber.Ctx) error
+	{pkgPath: "github.com/gofiber/fiber/v2", typeName: "Ctx", pointer: true, expr: "%s.UserContext()"},
+	// tests: t.Context() and friends, Go 1.24+
+	{pkgPath: "testing", typeName: "T", pointer: true, expr: "%s.Context()", testOnly: true},
+	{pkgPath: "testing", typeName: "B", pointer: true, expr: "%s.Context()", testOnly: true},
+	{pkgPath: "testing", typeName: "F", pointer: true, expr: "%s.Context()", testOnly: true},
+	{pkgPath: "testing", typeName: "TB", expr: "%s.Context()", testOnly: true},
+}
+
+// ctxSource is an in-scope variable whose type is a context provider.
+type ctxSource struct {
+	name     string
+	expr     string
+	availPos token.Pos
+	rank     int // index into contextProviders, accessorRank or receiverFieldRank
+
+	// field is the declaring position of the struct field the context is read
+	// from (-receiver-ctx), or token.NoPos.
+	field token.Pos
+}
+
+// accessorRank ranks a value with a context accessor method (-ctx-accessors) below
+// every provider: the framework types are known to carry the request's context.
+var accessorRank = len(contextProviders)
+
+// receiverFieldRank ranks a context.Context field of the method receiver below
+// everything else: a context stored in a struct is the least specific choice.
+var receiverFieldRank = accessorRank + 1
+
+// matchProvider returns the index of the first provider matching t.
+func matchProvider(t types.Type, firstParam, tests bool) (int, bool) {
+	ptr, isPtr := t.(*types.Pointer)
+	if isPtr {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return 0, false
+	}
+	for i, p := range contextProviders {
+		if p.pointer != isPtr || (p.firstParam && !firstParam) || (p.testOnly && !tests) {
+			continue
+		}
+		if named.Obj().Pkg().Path() == p.pkgPath && named.Obj().Name() == p.typeName {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// contextAccessors returns the method names given with -ctx-accessors.
+func contextAccessors() []string {
+	var names []string
+	for _, name := range strings.Split(flagCtxAccessors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// matchAccessor returns the first of the methods names that values of type t have
+// with signature func() context.Context.
+func matchAccessor(t types.Type, names []string) (string, bool) {
+	if len(names) == 0 {
+		return "", false
+	}
+	if _, ok := t.Underlying().(*types.Interface); !ok {
+		if _, ok := t.(*types.Pointer); !ok {
+			t = types.NewPointer(t) // variables are addressable
+		}
+	}
+	mset := types.NewMethodSet(t)
+	for _, name := range names {
+		for i := 0; i < mset.Len(); i++ {
+			fn := mset.At(i).Obj()
+			if fn.Name() != name {
+				continue
+			}
+			sig := fn.Type().(*types.Signature)
+			if sig.Params().Len() == 0 && sig.Results().Len() == 1 && isContextValue(sig.Results().At(0).Type()) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// declareAccessor adds id, of type t, to fr's sources from avail on if t has one
+// of the accessor methods names.
+func declareAccessor(fr *scopeFrame, id *ast.Ident, t types.Type, names []string, avail token.Pos) {
+	if method, ok := matchAccessor(t, names); ok {
+		fr.sources = append(fr.sources, ctxSource{
+			name:     id.Name,
+			expr:     id.Name + "." + method + "()",
+			availPos: avail,
+			rank:     accessorRank,
+		})
+	}
+}
+
+// dropSource forgets the sources held by a variable that is being redeclared; a
+// receiver can be both an accessor and a -receiver-ctx source.
+func (fr *scopeFrame) dropSource(name string) {
+	fr.sources = slices.DeleteFunc(fr.sources, func(src ctxSource) bool { return src.name == name })
+}
+
+// availableSources returns the sources available at pos, highest priority first;
+// sources of equal rank keep their declaration order.
+func (fr *scopeFrame) availableSources(pos token.Pos) []ctxSource {
+	var srcs []ctxSource
+	for _, src := range fr.sources {
+		if pos >= src.availPos {
+			srcs = append(srcs, src)
+		}
+	}
+	sort.SliceStable(srcs, func(i, j int) bool { return srcs[i].rank < srcs[j].rank })
+	return srcs
+}
+
+// declareReceiver adds the first context.Context field of the receiver recv, of
+// type t, to fr's sources.
+func declareReceiver(fr *scopeFrame, recv *ast.Ident, t types.Type) {
+	fields := contextFields(t)
+	if len(fields) == 0 {
+		return
+	}
+	fr.sources = append(fr.sources, ctxSource{
+		name:     recv.Name,
+		expr:     recv.Name + "." + fields[0].Name(),
+		availPos: recv.Pos(),
+		rank:     receiverFieldRank,
+		field:    fields[0].Pos(),
+	})
+}
+
+// contextFields returns the fields of type context.Context of the struct t, or
+// *t, is defined as.
+func contextFields(t types.Type) []*types.Var {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if _, ok := t.(*types.Named); !ok {
+		return nil
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	var fields []*types.Var
+	for i := 0; i < st.NumFields(); i++ {
+		if kind, ok := isContextType(st.Field(i).Type()); ok && kind == ctxValue {
+			fields = append(fields, st.Field(i))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namedType builds pkgPath.name without needing the package's source.
+func namedType(pkgPath, name string) *types.Named {
+	pkg := types.NewPackage(pkgPath, pkgPath)
+	return types.NewNamed(types.NewTypeName(0, pkg, name, nil), types.NewStruct(nil, nil), nil)
+}
+
+func TestMatchProvider(t *testing.T) {
+	cases := []struct {
+		typ        types.Type
+		firstParam bool
+		tests      bool
+		want       string // expression for a variable named c, "" for no match
+	}{
+		{types.NewPointer(namedType("net/http", "Request")), false, false, "c.Context()"},
+		{namedType("net/http", "Request"), false, false, ""},
+		{types.NewPointer(namedType("github.com/gin-gonic/gin", "Context")), false, false, "c.Request.Context()"},
+		{namedType("github.com/labstack/echo/v4", "Context"), false, false, "c.Request().Context()"},
+		{types.NewPointer(namedType("github.com/gofiber/fiber/v2", "Ctx")), false, false, "c.UserContext()"},
+		{namedType("context", "Context"), true, false, "c"},
+		{namedType("context", "Context"), false, false, ""},
+		{types.NewPointer(namedType("testing", "T")), false, false, ""},
+		{types.NewPointer(namedType("testing", "T")), false, true, "c.Context()"},
+		{namedType("testing", "TB"), false, true, "c.Context()"},
+	}
+	for _, tc := range cases {
+		rank, ok := matchProvider(tc.typ, tc.firstParam, tc.tests)
+		got := ""
+		if ok {
+			got = fmt.Sprintf(contextProviders[rank].expr, "c")
+		}
+		assert.Equal(t, tc.want, got, "%s (firstParam=%v, tests=%v)", tc.typ, tc.firstParam, tc.tests)
+	}
+}
+
+func TestRewriteContentProviders(t *testing.T) {
+	input := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func handler(w http.ResponseWriter, req *http.Request) {
+	do(context.TODO())
+	if true {
+		req := 1
+		do(context.TODO(), req)
+	}
+}
+
+func (s *server) Get(c context.Context, req *http.Request) {
+	do(context.TODO())
+}
+`
+	expected := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func handler(w http.ResponseWriter, req *http.Request) {
+	do(req.Context())
+	if true {
+		req := 1
+		do(context.TODO(), req)
+	}
+}
+
+func (s *server) Get(c context.Context, req *http.Request) {
+	do(c)
+}
+`
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRewriteContentReceiverContext(t *testing.T) {
+	input := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type server struct {
+	name string
+	ctx  context.Context
+}
+
+func (s *server) Run() {
+	do(context.TODO())
+}
+
+func (s *server) Handle(w http.ResponseWriter, r *http.Request) {
+	do(context.TODO())
+}
+
+func (s server) Shadowed() {
+	s := 1
+	do(context.TODO(), s)
+}
+
+func do(ctx context.Context, args ...any) {}
+`
+	expected := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type server struct {
+	name string
+	ctx  context.Context
+}
+
+func (s *server) Run() {
+	do(s.ctx)
+}
+
+func (s *server) Handle(w http.ResponseWriter, r *http.Request) {
+	do(r.Context())
+}
+
+func (s server) Shadowed() {
+	s := 1
+	do(context.TODO(), s)
+}
+
+func do(ctx context.Context, args ...any) {}
+`
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.NotContains(t, actual, "do(s.ctx)", "receiver fields are only used with -receiver-ctx")
+
+	flagReceiverCtx = true
+	defer func() { flagReceiverCtx = false }()
+	actual, err = RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestContextAccessors(t *testing.T) {
+	input := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type session struct {
+	ctx context.Context
+}
+
+func (s *session) Context() context.Context { return s.ctx }
+
+type job struct{}
+
+func (j job) Ctx() context.Context { return context.Background() }
+
+func (j job) Name() string { return "job" }
+
+func (s *session) Save() {
+	do(context.TODO())
+}
+
+func run(j job, r *http.Request) {
+	do(context.TODO())
+}
+
+func handle(j job) {
+	do(context.TODO())
+}
+
+func do(ctx context.Context, args ...any) {}
+`
+	expected := `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type session struct {
+	ctx context.Context
+}
+
+func (s *session) Context() context.Context { return s.ctx }
+
+type job struct{}
+
+func (j job) Ctx() context.Context { return context.Background() }
+
+func (j job) Name() string { return "job" }
+
+func (s *session) Save() {
+	do(s.Context())
+}
+
+func run(j job, r *http.Request) {
+	do(r.Context())
+}
+
+func handle(j job) {
+	do(j.Ctx())
+}
+
+func do(ctx context.Context, args ...any) {}
+`
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "func handle(j job) {\n\tdo(context.TODO())", "accessors are only used with -ctx-accessors")
+
+	flagCtxAccessors = "Context, Ctx, Name"
+	defer func() { flagCtxAccessors = "" }()
+	actual, err = RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestRewriteContentRedeclaredReceiver(t *testing.T) {
+	input := `package main
+
+import "context"
+
+type session struct {
+	ctx context.Context
+}
+
+func (s *session) Context() context.Context { return s.ctx }
+
+func (s *session) Save() {
+	{
+		s := "name"
+		do(context.TODO(), s)
+	}
+	do(context.TODO())
+}
+
+func do(ctx context.Context, args ...any) {}
+`
+	flagCtxAccessors, flagReceiverCtx = "Context", true
+	defer func() { flagCtxAccessors, flagReceiverCtx = "", false }()
+	actual, err := RewriteContent(input)
+	assert.NoError(t, err)
+	assert.Contains(t, actual, "s := \"name\"\n\t\tdo(context.TODO(), s)", "the string s provides no context")
+	assert.Contains(t, actual, "}\n\tdo(s.Context())")
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// derefHelper is the function added to packages that pass a possibly-nil
+// *context.Context into a migrated parameter or field.
+const derefHelper = "derefContext"
+
+// ptrMigration rewrites *context.Context parameters and struct fields to
+// context.Context (-migrate-ptr-ctx) and updates every use the type checker can see:
+// `*ctx` becomes `ctx`, callers passing `&ctx` pass `ctx`, `nil` becomes
+// context.TODO(), and any other pointer is passed through a nil-guarding helper.
+//
+// Objects are keyed by declaring position rather than identity: test variants of a
+// package are type-checked separately and declare distinct objects for the same source.
+type ptrMigration struct {
+	editSet
+
+	funcs        map[funcKey][]int  // functions with *context.Context params -> param indices
+	methods      map[funcKey]string // method name by position, for interface checks
+	ifaceMethods map[string]bool    // interface methods taking a *context.Context
+	excluded     map[funcKey]string // functions left alone, with the reason
+	objs         map[funcKey]bool   // migrated parameters and struct fields
+
+	needsHelper map[string]bool // package path -> derefContext is called
+	hasHelper   map[string]bool // package path -> derefContext already declared
+}
+
+// migrationUnit is a file together with the package variant it is processed in.
+type migrationUnit struct {
+	pkg  *packages.Package
+	file *ast.File
+}
+
+// migrationUnits returns each file of pkgs once, in the first package variant that
+// contains it.
+func migrationUnits(pkgs []*packages.Package) []migrationUnit {
+	var units []migrationUnit
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue // synthesized test main package
+		}
+		for _, file := range pkg.Syntax {
+			filename := pkg.Fset.File(file.Pos()).Name()
+			if !seen[filename] {
+				seen[filename] = true
+				units = append(units, migrationUnit{pkg, file})
+			}
+		}
+	}
+	return units
+}
+
+// isContextPtr reports whether t is exactly *context.Context.
+func isContextPtr(t types.Type) bool {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	kind, ok := isContextType(ptr.Elem())
+	return ok && kind == ctxValue
+}
+
+// runPointerMigration implements -migrate-ptr-ctx and returns the exit status.
+func runPointerMigration(configs []buildConfig, patterns []string) int {
+	pkgs, status := loadWholeProgram("-migrate-ptr-ctx", configs, patterns, true)
+	if pkgs == nil {
+		return status
+	}
+	return applyResults(migratePointerContexts(pkgs))
+}
+
+// loadWholeProgram loads patterns for a mode that rewrites declarations together
+// with their uses. Uses can only be updated if they were type-checked, so
+// ill-typed packages abort the mode instead of being skipped. With signatures, the
+// mode changes function signatures and must update every caller: test files are
+// then loaded too, -tests or not. On failure it returns nil and the exit status.
+func loadWholeProgram(mode string, configs []buildConfig, patterns []string, signatures bool) ([]*packages.Package, int) {
+	if len(configs) != 1 {
+		log.Printf("%s works on a single build configuration; drop -platforms", mode)
+		return nil, 2
+	}
+	cfg := configs[0].packagesConfig()
+	if signatures {
+		cfg.Tests = true // a caller left in a _test.go file breaks go test
+	}
+	pkgs, err := loadPackages(cfg, patterns...)
+	if err != nil {
+		log.Printf("packages.Load: %v", err)
+		return nil, 1
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		log.Printf("packages had errors; fix them before running %s", mode)
+		return nil, 1
+	}
+	return pkgs, 0
+}
+
+// applyResults writes the edits of results (or, with -dry-run, only prints them)
+// and returns the exit status.
+func applyResults(results []fileResult) int {
+	status := 0
+	for _, res := range results {
+		if !flagDryRun {
+			res.err = applyFindings(res.filename, res.findings)
+		}
+		for _, f := range res.findings {
+			if f.text == "" {
+				continue
+			}
+			if flagDryRun {
+				fmt.Printf("[DRY] %s:%d: %s\n", f.pos.Filename, f.pos.Line, f.text)
+			} else if res.err == nil {
+				fmt.Printf("✅ %s:%d: %s\n", f.pos.Filename, f.pos.Line, f.text)
+			}
+		}
+		if res.err != nil {
+			log.Printf("[ERROR] %s: %v", res.filename, res.err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// migratePointerContexts computes the migration for pkgs, which must all come from
+// one loadPackages call, and returns the edits per file.
+func migratePointerContexts(pkgs []*packages.Package) []fileResult {
+	if len(pkgs) == 0 {
+		return nil
+	}
+	m := &ptrMigration{
+		editSet:      newEditSet(pkgs[0].Fset),
+		funcs:        map[funcKey][]int{},
+		methods:      map[funcKey]string{},
+		ifaceMethods: map[string]bool{},
+		excluded:     map[funcKey]string{},
+		objs:         map[funcKey]bool{},
+		needsHelper:  map[string]bool{},
+		hasHelper:    map[string]bool{},
+	}
+
+	units := migrationUnits(pkgs)
+	for _, u := range units {
+		if u.pkg.Types.Scope().Lookup(derefHelper) != nil {
+			m.hasHelper[u.pkg.PkgPath] = true
+		}
+	}
+
+	for _, u := range units {
+		m.collect(u.pkg.TypesInfo, u.file)
+	}
+	for key, name := range m.methods {
+		if m.ifaceMethods[name] {
+			m.excluded[key] = "method " + name + " may implement an interface"
+		}
+	}
+	for _, u := range units {
+		m.excludeFuncValues(u.pkg.TypesInfo, u.file)
+		m.excludeWritesThrough(u.pkg.TypesInfo, u.file)
+	}
+	m.reportExcluded()
+
+	for _, u := range units {
+		m.rewriteDecls(u.pkg.TypesInfo, u.file)
+	}
+	for _, u := range units {
+		m.rewriteUses(u.pkg, u.file)
+	}
+	m.addHelpers(units)
+
+	return m.results()
+}
+
+// key identifies obj by its declaration, also when another module's package
+// refers to it through export data.
+func (m *ptrMigration) key(obj types.Object) funcKey {
+	p := m.fset.Position(obj.Pos())
+	return funcKey{p.Filename, p.Line, obj.Name()}
+}
+
+// collect finds functions with *context.Context parameters, struct fields of that
+// type and interface methods that would constrain a signature change.
+func (m *ptrMigration) collect(info *types.Info, file *ast.File) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncDecl:
+			fn, _ := info.Defs[node.Name].(*types.Func)
+			if fn == nil {
+				return true
+			}
+			sig := fn.Type().(*types.Signature)
+			var idx []int
+			for i := 0; i < sig.Params().Len(); i++ {
+				if isContextPtr(sig.Params().At(i).Type()) {
+					idx = append(idx, i)
+				}
+			}
+			if len(idx) == 0 {
+				return true
+			}
+			m.funcs[m.key(fn)] = idx
+			if node.Body == nil {
+				m.excluded[m.key(fn)] = "declared without a body"
+			}
+			if sig.Recv() != nil {
+				m.methods[m.key(fn)] = fn.Name()
+			}
+		case *ast.StructType:
+			for _, fld := range node.Fields.List {
+				for _, nm := range fld.Names {
+					if obj := info.Defs[nm]; obj != nil && isContextPtr(obj.Type()) {
+						m.objs[m.key(obj)] = true
+					}
+				}
+			}
+		case *ast.InterfaceType:
+			for _, meth := range node.Methods.List {
+				ft, ok := meth.Type.(*ast.FuncType)
+				if !ok || len(meth.Names) == 0 {
+					continue
+				}
+				for _, p := range ft.Params.List {
+					if t := info.TypeOf(p.Type); t != nil && isContextPtr(t) {
+						m.ifaceMethods[meth.Names[0].Name] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// excludeFuncValues excludes functions that are referenced other than by a direct
+// call: their signature may have to match a function type elsewhere.
+func (m *ptrMigration) excludeFuncValues(info *types.Info, file *ast.File) {
+	called := map[*ast.Ident]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if id := calleeIdent(call); id != nil {
+				called[id] = true
+			}
+		}
+		return true
+	})
+	ast.Inspect(file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || called[id] {
+			return true
+		}
+		if fn, ok := info.Uses[id].(*types.Func); ok {
+			if _, migrated := m.funcs[m.key(fn.Origin())]; migrated {
+				m.excluded[m.key(fn.Origin())] = "used as a function value"
+			}
+		}
+		return true
+	})
+}
+
+// excludeWritesThrough excludes functions and fields whose pointer is assigned
+// through (`*ctx = ...`): after the migration the assignment would only change a
+// copy, and the caller would no longer see the new context.
+func (m *ptrMigration) excludeWritesThrough(info *types.Info, file *ast.File) {
+	params := map[funcKey]funcKey{} // migrated parameter -> its function
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncDecl:
+			fn, _ := info.Defs[node.Name].(*types.Func)
+			if fn == nil {
+				return true
+			}
+			sig := fn.Type().(*types.Signature)
+			for _, i := range m.funcs[m.key(fn)] {
+				params[m.key(sig.Params().At(i))] = m.key(fn)
+			}
+		case *ast.AssignStmt:
+			for _, lhs := range node.Lhs {
+				star, ok := ast.Unparen(lhs).(*ast.StarExpr)
+				if !ok {
+					continue
+				}
+				var id *ast.Ident
+				switch x := ast.Unparen(star.X).(type) {
+				case *ast.Ident:
+					id = x
+				case *ast.SelectorExpr:
+					id = x.Sel
+				}
+				v, ok := info.Uses[id].(*types.Var)
+				if !ok {
+					continue
+				}
+				key := m.key(v.Origin())
+				if fn, ok := params[key]; ok {
+					m.excluded[fn] = "assigns through parameter " + v.Name()
+				} else if v.IsField() && m.objs[key] {
+					delete(m.objs, key)
+					m.excluded[key] = "assigned through"
+				}
+			}
+		}
+		return true
+	})
+}
+
+// reportExcluded logs the functions and fields that are left alone.
+func (m *ptrMigration) reportExcluded() {
+	var skipped []funcKey
+	for key := range m.excluded {
+		skipped = append(skipped, key)
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].less(skipped[j]) })
+	for _, key := range skipped {
+		what := "parameters"
+		if _, ok := m.funcs[key]; !ok {
+			what = "field " + key.name
+		}
+		log.Printf("[SKIP] %s:%d: *context.Context %s not migrated: %s", key.filename, key.line, what, m.excluded[key])
+	}
+}
+
+// migratedParams returns the parameter indices of fn that are being migrated.
+func (m *ptrMigration) migratedParams(fn *types.Func) []int {
+	key := m.key(fn.Origin())
+	if _, skip := m.excluded[key]; skip {
+		return nil
+	}
+	return m.funcs[key]
+}
+
+// rewriteDecls drops the `*` from migrated parameter and field types.
+func (m *ptrMigration) rewriteDecls(info *types.Info, file *ast.File) {
+	dropStar := func(fld *ast.Field, what string) {
+		star, ok := fld.Type.(*ast.StarExpr)
+		if !ok {
+			return
+		}
+		e := edit{start: m.offset(star.Star), end: m.offset(star.Star) + 1}
+		m.note(star.Pos(), what+" *context.Context → context.Context", e)
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncDecl:
+			fn, _ := info.Defs[node.Name].(*types.Func)
+			if fn == nil || len(m.migratedParams(fn)) == 0 {
+				return true
+			}
+			for _, fld := range node.Type.Params.List {
+				t := info.TypeOf(fld.Type)
+				if t == nil || !isContextPtr(t) {
+					continue
+				}
+				names := []string{}
+				for _, nm := range fld.Names {
+					if obj := info.Defs[nm]; obj != nil {
+						m.objs[m.key(obj)] = true
+					}
+					names = append(names, nm.Name)
+				}
+				dropStar(fld, fmt.Sprintf("%s: parameter %s", node.Name.Name, strings.Join(names, ", ")))
+			}
+		case *ast.StructType:
+			for _, fld := range node.Fields.List {
+				if len(fld.Names) > 0 {
+					if obj := info.Defs[fld.Names[0]]; obj != nil && m.objs[m.key(obj)] {
+						dropStar(fld, "field "+fld.Names[0].Name)
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// rewriteUses updates the expressions that read or feed migrated parameters and fields.
+func (m *ptrMigration) rewriteUses(pkg *packages.Package, file *ast.File) {
+	info := pkg.TypesInfo
+	slotted := map[ast.Expr]bool{} // values flowing into migrated slots, handled by slot
+	slot := func(e ast.Expr) {
+		slotted[ast.Unparen(e)] = true
+		m.slot(pkg, file, e)
+	}
+
+	var stack []ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			if id := calleeIdent(node); id != nil {
+				if fn, ok := info.Uses[id].(*types.Func); ok {
+					for _, i := range m.migratedParams(fn) {
+						if i < len(node.Args) {
+							slot(node.Args[i])
+						}
+					}
+				}
+			}
+		case *ast.CompositeLit:
+			st, _ := typeUnderlying(info.TypeOf(node)).(*types.Struct)
+			for i, elt := range node.Elts {
+				var field *types.Var
+				val := elt
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if id, ok := kv.Key.(*ast.Ident); ok {
+						field, _ = info.Uses[id].(*types.Var)
+					}
+					val = kv.Value
+				} else if st != nil && i < st.NumFields() {
+					field = st.Field(i)
+				}
+				if field != nil && m.objs[m.key(field)] {
+					slot(val)
+				}
+			}
+		case *ast.AssignStmt:
+			if node.Tok == token.ASSIGN && len(node.Lhs) == len(node.Rhs) {
+				for i, lhs := range node.Lhs {
+					if m.isMigratedRef(info, lhs) {
+						slot(node.Rhs[i])
+					}
+				}
+			}
+		case *ast.Ident:
+			if obj := info.Uses[node]; obj != nil && m.objs[m.key(obj)] {
+				m.rewriteRef(stack, slotted)
+			}
+		}
+		return true
+	})
+}
+
+// rewriteRef adapts one reference to a migrated object; stack ends with its identifier.
+func (m *ptrMigration) rewriteRef(stack []ast.Node, slotted map[ast.Expr]bool) {
+	id := stack[len(stack)-1].(*ast.Ident)
+	var e ast.Expr = id
+	j := len(stack) - 2
+	if j >= 0 {
+		if sel, ok := stack[j].(*ast.SelectorExpr); ok && sel.Sel == id {
+			e = sel
+			j--
+		}
+	}
+	if j >= 0 {
+		if kv, ok := stack[j].(*ast.KeyValueExpr); ok && kv.Key == e {
+			return // field name in a composite literal
+		}
+	}
+	for j >= 0 {
+		if _, ok := stack[j].(*ast.ParenExpr); !ok {
+			break
+		}
+		j--
+	}
+	if slotted[e] {
+		return // already a value after the migration
+	}
+	if j >= 0 {
+		switch p := stack[j].(type) {
+		case *ast.StarExpr:
+			m.note(p.Star, types.ExprString(p)+" → "+types.ExprString(p.X), edit{start: m.offset(p.Star), end: m.offset(p.Star) + 1})
+			return
+		case *ast.UnaryExpr:
+			if p.Op == token.AND {
+				return
+			}
+		case *ast.BinaryExpr:
+			if isNilIdent(p.X) || isNilIdent(p.Y) {
+				return // nil checks still work on the interface value
+			}
+		case *ast.AssignStmt:
+			for _, lhs := range p.Lhs {
+				if ast.Unparen(lhs) == e {
+					return
+				}
+			}
+		}
+	}
+	// anything else still expects a pointer
+	start := m.offset(e.Pos())
+	m.note(e.Pos(), types.ExprString(e)+" → &"+types.ExprString(e), edit{start: start, end: start, text: "&"})
+}
+
+// slot adapts a value flowing into a migrated parameter or field.
+func (m *ptrMigration) slot(pkg *packages.Package, file *ast.File, e ast.Expr) {
+	info := pkg.TypesInfo
+	x := ast.Unparen(e)
+	start, end := m.offset(x.Pos()), m.offset(x.End())
+	switch {
+	case isAddrOf(x):
+		m.note(x.Pos(), types.ExprString(x)+" → "+types.ExprString(x.(*ast.UnaryExpr).X), edit{start: start, end: start + 1})
+	case isNilIdent(x):
+		q := m.contextName(file)
+		m.note(x.Pos(), "nil → "+q+".TODO()", edit{start: start, end: end, text: q + ".TODO()"})
+	case m.isMigratedRef(info, x):
+		// already a context.Context after the migration
+	default:
+		m.needsHelper[pkg.PkgPath] = true
+		m.note(x.Pos(), types.ExprString(x)+" → "+derefHelper+"("+types.ExprString(x)+") (may be nil)", edit{start: start, end: start, text: derefHelper + "("})
+		m.note(x.End(), "", edit{start: end, end: end, text: ")"})
+	}
+}
+
+// addHelpers appends derefContext to one file of every package that calls it
+// without declaring it, preferring non-test files.
+func (m *ptrMigration) addHelpers(units []migrationUnit) {
+	chosen := map[string]migrationUnit{}
+	for _, u := range units {
+		path := u.pkg.PkgPath
+		if !m.needsHelper[path] || m.hasHelper[path] {
+			continue
+		}
+		cur, ok := chosen[path]
+		name := m.fset.File(u.file.Pos()).Name()
+		if !ok || (strings.HasSuffix(m.fset.File(cur.file.Pos()).Name(), "_test.go") && !strings.HasSuffix(name, "_test.go")) {
+			chosen[path] = u
+		}
+	}
+	for _, u := range chosen {
+		q := m.contextName(u.file)
+		tf := m.fset.File(u.file.Pos())
+		text := fmt.Sprintf(`
+// %[1]s returns *p, or %[2]s.TODO() when p is nil.
+func %[1]s(p *%[2]s.Context) %[2]s.Context {
+	if p == nil {
+		return %[2]s.TODO()
+	}
+	return *p
+}
+`, derefHelper, q)
+		m.note(u.file.End(), "added "+derefHelper+" helper", edit{start: tf.Size(), end: tf.Size(), text: text})
+	}
+}
+
+// isMigratedRef reports whether e refers to a migrated parameter or field.
+func (m *ptrMigration) isMigratedRef(info *types.Info, e ast.Expr) bool {
+	switch x := ast.Unparen(e).(type) {
+	case *ast.Ident:
+		obj := info.Uses[x]
+		return obj != nil && m.objs[m.key(obj)]
+	case *ast.SelectorExpr:
+		obj := info.Uses[x.Sel]
+		return obj != nil && m.objs[m.key(obj)]
+	}
+	return false
+}
+
+// calleeIdent returns the identifier naming the function a call invokes directly.
+func calleeIdent(call *ast.CallExpr) *ast.Ident {
+	switch fn := ast.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		return fn
+	case *ast.SelectorExpr:
+		return fn.Sel
+	}
+	return nil
+}
+
+func typeUnderlying(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	return t.Underlying()
+}
+
+func isAddrOf(e ast.Expr) bool {
+	u, ok := e.(*ast.UnaryExpr)
+	return ok && u.Op == token.AND
+}
+
+func isNilIdent(e ast.Expr) bool {
+	id, ok := ast.Unparen(e).(*ast.Ident)
+	return ok && id.Name == "nil"
+}
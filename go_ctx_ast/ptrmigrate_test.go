@@ -0,0 +1,284 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/packages"
+)
+
+// testPackages type-checks in-memory packages (import path -> file name -> source),
+// in the given order, standing in for packages.Load.
+func testPackages(t *testing.T, fset *token.FileSet, order []string, srcs map[string]map[string]string) []*packages.Package {
+	checked := map[string]*types.Package{}
+	std := importer.Default()
+	imp := importerFunc(func(path string) (*types.Package, error) {
+		if p, ok := checked[path]; ok {
+			return p, nil
+		}
+		return std.Import(path)
+	})
+	var pkgs []*packages.Package
+	for _, path := range order {
+		var names []string
+		for name := range srcs[path] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var files []*ast.File
+		for _, name := range names {
+			f, err := parser.ParseFile(fset, name, srcs[path][name], parser.ParseComments)
+			assert.NoError(t, err)
+			files = append(files, f)
+		}
+		info := &types.Info{
+			Types: map[ast.Expr]types.TypeAndValue{},
+			Defs:  map[*ast.Ident]types.Object{},
+			Uses:  map[*ast.Ident]types.Object{},
+		}
+		conf := types.Config{Importer: imp}
+		tpkg, err := conf.Check(path, fset, files, info)
+		assert.NoError(t, err)
+		checked[path] = tpkg
+		pkgs = append(pkgs, &packages.Package{ID: path, Name: tpkg.Name(), PkgPath: path, Fset: fset, Syntax: files, Types: tpkg, TypesInfo: info})
+	}
+	return pkgs
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+func TestMigratePointerContexts(t *testing.T) {
+	srcs := map[string]map[string]string{
+		"example.com/svc": {"svc.go": `package svc
+
+import "context"
+
+type Job struct {
+	ctx  *context.Context
+	name string
+}
+
+func Run(ctx *context.Context, name string) {
+	if ctx == nil {
+		return
+	}
+	use(*ctx)
+	j := Job{ctx: ctx, name: name}
+	j.start()
+}
+
+func (j *Job) start() {
+	use(*j.ctx)
+	keep(j.ctx)
+}
+
+// keep is used as a value, so its signature must stay.
+var _ = keep
+
+func keep(p *context.Context) {}
+
+func use(ctx context.Context) {}
+`},
+		"example.com/app": {"app.go": `package app
+
+import (
+	"context"
+
+	"example.com/svc"
+)
+
+func main(p *context.Context) {
+	ctx := context.Background()
+	svc.Run(&ctx, "a")
+	svc.Run(nil, "b")
+	svc.Run(p, "c")
+	svc.Run(lookup(), "d")
+}
+
+func lookup() *context.Context { return nil }
+`},
+		// a caller in a test file, which only a load with tests sees
+		"example.com/svc_test": {"svc_test.go": `package svc_test
+
+import (
+	"context"
+	"testing"
+
+	"example.com/svc"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	svc.Run(&ctx, "t")
+}
+`},
+	}
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc", "example.com/app", "example.com/svc_test"}, srcs)
+
+	out := map[string]string{}
+	for _, res := range migratePointerContexts(pkgs) {
+		var edits []edit
+		for _, f := range res.findings {
+			edits = append(edits, f.edit)
+		}
+		var src string
+		for _, files := range srcs {
+			src += files[res.filename]
+		}
+		patched, err := applyEdits([]byte(src), edits)
+		assert.NoError(t, err)
+		out[res.filename] = string(patched)
+	}
+
+	assert.Equal(t, `package svc
+
+import "context"
+
+type Job struct {
+	ctx  context.Context
+	name string
+}
+
+func Run(ctx context.Context, name string) {
+	if ctx == nil {
+		return
+	}
+	use(ctx)
+	j := Job{ctx: ctx, name: name}
+	j.start()
+}
+
+func (j *Job) start() {
+	use(j.ctx)
+	keep(&j.ctx)
+}
+
+// keep is used as a value, so its signature must stay.
+var _ = keep
+
+func keep(p *context.Context) {}
+
+func use(ctx context.Context) {}
+`, out["svc.go"])
+	assert.Equal(t, `package app
+
+import (
+	"context"
+
+	"example.com/svc"
+)
+
+func main(p context.Context) {
+	ctx := context.Background()
+	svc.Run(ctx, "a")
+	svc.Run(context.TODO(), "b")
+	svc.Run(p, "c")
+	svc.Run(derefContext(lookup()), "d")
+}
+
+func lookup() *context.Context { return nil }
+
+// derefContext returns *p, or context.TODO() when p is nil.
+func derefContext(p *context.Context) context.Context {
+	if p == nil {
+		return context.TODO()
+	}
+	return *p
+}
+`, out["app.go"])
+	assert.Equal(t, `package svc_test
+
+import (
+	"context"
+	"testing"
+
+	"example.com/svc"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	svc.Run(ctx, "t")
+}
+`, out["svc_test.go"])
+}
+
+func TestMigratePointerContextsWriteThrough(t *testing.T) {
+	src := `package svc
+
+import "context"
+
+type Holder struct {
+	ctx *context.Context
+}
+
+func (h *Holder) reset() {
+	*h.ctx = context.Background()
+}
+
+func Attach(ctx *context.Context, key string) {
+	*ctx = context.WithValue(*ctx, key, key)
+}
+
+func Read(ctx *context.Context) {
+	use(*ctx)
+}
+
+func use(ctx context.Context) {}
+`
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc"}, map[string]map[string]string{"example.com/svc": {"svc.go": src}})
+	m := &ptrMigration{
+		editSet:      newEditSet(fset),
+		funcs:        map[funcKey][]int{},
+		methods:      map[funcKey]string{},
+		ifaceMethods: map[string]bool{},
+		excluded:     map[funcKey]string{},
+		objs:         map[funcKey]bool{},
+	}
+	m.collect(pkgs[0].TypesInfo, pkgs[0].Syntax[0])
+	m.excludeWritesThrough(pkgs[0].TypesInfo, pkgs[0].Syntax[0])
+	assert.Equal(t, map[funcKey]string{
+		{"svc.go", 6, "ctx"}:     "assigned through",
+		{"svc.go", 13, "Attach"}: "assigns through parameter ctx",
+	}, m.excluded)
+
+	var edits []edit
+	for _, res := range migratePointerContexts(pkgs) {
+		for _, f := range res.findings {
+			edits = append(edits, f.edit)
+		}
+	}
+	out, err := applyEdits([]byte(src), edits)
+	assert.NoError(t, err)
+	assert.Equal(t, `package svc
+
+import "context"
+
+type Holder struct {
+	ctx *context.Context
+}
+
+func (h *Holder) reset() {
+	*h.ctx = context.Background()
+}
+
+func Attach(ctx *context.Context, key string) {
+	*ctx = context.WithValue(*ctx, key, key)
+}
+
+func Read(ctx context.Context) {
+	use(ctx)
+}
+
+func use(ctx context.Context) {}
+`, string(out))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Statuses of the sites in the JSON report (-json).
+const (
+	statusReplaced     = "replaced"
+	statusReplaceable  = "replaceable" // with -dry-run
+	statusFailed       = "failed"      // the file could not be rewritten
+	statusInconsistent = "inconsistent"
+	statusUnresolved   = "unresolved"
+	statusSuppressed   = "suppressed"
+)
+
+// jsonReport lists every context.TODO() call the run looked at.
+type jsonReport struct {
+	Sites []reportSite `json:"sites"`
+}
+
+type reportSite struct {
+	File          string `json:"file"`
+	Line          int    `json:"line"`
+	Column        int    `json:"column"`
+	Function      string `json:"function,omitempty"`
+	Status        string `json:"status"`
+	Replacement   string `json:"replacement,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Justification string `json:"justification,omitempty"`
+	// Suggestion is a command that fixes an unresolved site another way.
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// buildReport returns the report for results once their findings have been
+// applied (or not, with dryRun).
+func buildReport(results []fileResult, dryRun bool) jsonReport {
+	r := jsonReport{Sites: []reportSite{}}
+	add := func(f finding, status, reason string) {
+		if reason == "" {
+			reason = f.review
+		}
+		replacement := f.text
+		if f.span {
+			replacement = goSpanCtx
+		}
+		for _, pos := range f.todoSites() {
+			r.Sites = append(r.Sites, reportSite{
+				File: pos.Filename, Line: pos.Line, Column: pos.Column,
+				Function: f.fn, Status: status, Replacement: replacement, Reason: reason,
+			})
+		}
+	}
+	for _, res := range results {
+		for _, f := range res.findings {
+			switch {
+			case dryRun:
+				add(f, statusReplaceable, "")
+			case res.err != nil:
+				add(f, statusFailed, res.err.Error())
+			default:
+				add(f, statusReplaced, "")
+			}
+		}
+		for _, f := range res.inconsistent {
+			add(f, statusInconsistent, "resolves differently across build configurations")
+		}
+		for _, u := range res.unresolved {
+			s := reportSite{
+				File: u.pos.Filename, Line: u.pos.Line, Column: u.pos.Column,
+				Function: u.fn, Status: statusUnresolved, Reason: u.reason,
+			}
+			switch {
+			case u.reason == reasonSuppressed:
+				s.Status, s.Reason, s.Justification = statusSuppressed, "", u.detail
+			case u.detail != "":
+				s.Reason += ": " + u.detail
+			case u.reason == reasonNoContext && u.fn != "":
+				s.Suggestion = fmt.Sprintf("go_ctx_ast add-ctx-param %s:%d", u.pos.Filename, u.pos.Line)
+			}
+			r.Sites = append(r.Sites, s)
+		}
+	}
+	sort.SliceStable(r.Sites, func(i, j int) bool {
+		a, b := r.Sites[i], r.Sites[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return r
+}
+
+// writeReport writes r as indented JSON.
+func writeReport(w io.Writer, r jsonReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"go/version"
+	"slices"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// scopeFrame represents the availability of ctx and other context sources at/after
+// certain positions. Availability positions are token.Pos values within the file's FileSet.
+type scopeFrame struct {
+	// ctxKind and ctxAvailPos indicate whether `ctx` is available (value or pointer)
+	// and from which position onward (the identifier position).
+	ctxKind     ctxKind
+	ctxAvailPos token.Pos
+
+	// sources are the in-scope variables whose type is a registered context
+	// provider (e.g. `r *http.Request`).
+	sources []ctxSource
+
+	// brokenPos, if valid, is where a ctx or r declaration failed to type-check
+	// (ill-typed packages only); sites after it are left alone.
+	brokenPos token.Pos
+}
+
+// skipInterval marks ranges (pos..end) inside which we must not rewrite (anonymous goroutine bodies).
+type skipInterval struct {
+	start token.Pos
+	end   token.Pos
+}
+
+// callSite describes the scope at a call expression visited by walkScopes.
+type callSite struct {
+	// ctxExpr is the expression that supplies a context at the call ("ctx",
+	// "r.Context()", ...), or "" if nothing usable is in scope.
+	ctxExpr string
+	// skip is set inside functions and goroutine bodies that must not be rewritten.
+	skip bool
+	// ctxField is the declaring position of the receiver field ctxExpr reads, if any.
+	ctxField token.Pos
+	// ctxAvail is where the variable ctxExpr reads came into scope.
+	ctxAvail token.Pos
+	// decl is the enclosing function declaration, nil at package level.
+	decl *ast.FuncDecl
+	// alternatives are the other sources in scope, in priority order.
+	alternatives []string
+}
+
+// resolve returns the context source available at pos, in priority order:
+//  1. ctx (if ctxKind != ctxNone and pos >= ctxAvailPos)
+//  2. *ctx if pointer
+//  3. the highest-ranked context provider in scope (r.Context(), c.UserContext(), ...)
+//  4. a value with a context accessor method, with -ctx-accessors
+//  5. a context.Context field of the receiver, with -receiver-ctx
+func (fr *scopeFrame) resolve(pos token.Pos) (ctxSource, bool) {
+	srcs := fr.candidates(pos)
+	if len(srcs) == 0 {
+		return ctxSource{}, false
+	}
+	return srcs[0], true
+}
+
+// candidates returns every context source available at pos, in the order resolve
+// prefers them.
+func (fr *scopeFrame) candidates(pos token.Pos) []ctxSource {
+	if fr.brokenPos.IsValid() && pos >= fr.brokenPos {
+		// resolution would depend on an ill-typed declaration
+		return nil
+	}
+	var srcs []ctxSource
+	if fr.ctxKind != ctxNone && pos >= fr.ctxAvailPos {
+		expr := "ctx"
+		if fr.ctxKind == ctxPointer {
+			expr = "*ctx"
+		}
+		srcs = append(srcs, ctxSource{name: "ctx", expr: expr, availPos: fr.ctxAvailPos})
+	}
+	return append(srcs, fr.availableSources(pos)...)
+}
+
+// walkScopes walks file tracking which context sources are in scope and calls visit
+// for every call expression. visit returning false skips the call's children.
+//
+// partial marks type information from an ill-typed package (see findReplacements).
+func walkScopes(info *types.Info, file *ast.File, partial bool, visit func(call *ast.CallExpr, site callSite) bool) {
+	// t.Context() only exists from Go 1.24 on; honour the file's language version.
+	useTestCtx := flagTestContext
+	if v := info.FileVersions[file]; v != "" && version.Compare(v, "go1.24") < 0 {
+		useTestCtx = false
+	}
+	accessors := contextAccessors()
+
+	// First pass: find goroutine skips:
+	// - anonymous func literals in `go func(...) { ... }(...)` (skip their body only)
+	// - resolved functions invoked via `go someFunc(...)` or `go pkg.Func(...)` (skip whole target function)
+	skipRanges := []skipInterval{}
+	skipFuncs := map[*types.Func]bool{}
+	goLits := map[*ast.FuncLit]bool{}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		gs, ok := n.(*ast.GoStmt)
+		if !ok {
+			return true
+		}
+		call := gs.Call
+		// anonymous literal
+		if funLit, ok := call.Fun.(*ast.FuncLit); ok {
+			goLits[funLit] = true
+			if funLit.Body != nil {
+				skipRanges = append(skipRanges, skipInterval{start: funLit.Body.Lbrace, end: funLit.Body.Rbrace})
+			}
+			return true
+		}
+		// named or selector: try resolve the function object and mark skipFuncs
+		switch fn := call.Fun.(type) {
+		case *ast.Ident:
+			if obj := info.Uses[fn]; obj != nil {
+				if tf, ok := obj.(*types.Func); ok {
+					skipFuncs[tf] = true
+				}
+			}
+		case *ast.SelectorExpr:
+			// selector.Sel is an Ident; Try to look up Uses for the Sel
+			if sel := fn.Sel; sel != nil {
+				if obj := info.Uses[sel]; obj != nil {
+					if tf, ok := obj.(*types.Func); ok {
+						skipFuncs[tf] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	// Helper: test if pos lies inside any skipRange
+	insideSkipRange := func(pos token.Pos) bool {
+		for _, r := range skipRanges {
+			if pos >= r.start && pos <= r.end {
+				return true
+			}
+		}
+		return false
+	}
+
+	// frame stack for scoping; each frame inherits parent's values on push
+	var frameStack []scopeFrame
+	pushFrame := func(copyFrom *scopeFrame) {
+		if copyFrom == nil {
+			frameStack = append(frameStack, scopeFrame{})
+			return
+		}
+		fr := *copyFrom
+		fr.sources = slices.Clone(fr.sources)
+		frameStack = append(frameStack, fr)
+	}
+	popFrame := func() {
+		if len(frameStack) == 0 {
+			return
+		}
+		frameStack = frameStack[:len(frameStack)-1]
+	}
+	currentFrame := func() *scopeFrame {
+		if len(frameStack) == 0 {
+			return nil
+		}
+		return &frameStack[len(frameStack)-1]
+	}
+
+	// declare records what a newly declared identifier (of type t, nil if unknown)
+	// makes available in the current frame from avail on: `ctx` itself, or a context
+	// provider. The name no longer refers to what it did before, so neither is
+	// available in between, e.g. in the initializer of a variable declaration.
+	declare := func(id *ast.Ident, t types.Type, firstParam bool, avail token.Pos) {
+		fr := currentFrame()
+		if fr == nil {
+			return // package-level var, not in any function scope
+		}
+		// In an ill-typed package, an untyped ctx, r or parameter may have been a
+		// context source; anything after it could resolve differently once fixed.
+		if partial && !isValidType(t) && (firstParam || id.Name == "ctx" || id.Name == "r") && !fr.brokenPos.IsValid() {
+			fr.brokenPos = id.Pos()
+		}
+		if t == nil {
+			return
+		}
+		// a redeclaration shadows whatever the name provided before
+		fr.dropSource(id.Name)
+		if id.Name == "ctx" {
+			fr.ctxKind, _ = isContextType(t)
+			fr.ctxAvailPos = avail
+			if fr.ctxKind != ctxNone {
+				return
+			}
+		}
+		if rank, ok := matchProvider(t, firstParam, useTestCtx); ok {
+			fr.sources = append(fr.sources, ctxSource{
+				name:     id.Name,
+				expr:     fmt.Sprintf(contextProviders[rank].expr, id.Name),
+				availPos: avail,
+				rank:     rank,
+			})
+			return
+		}
+		declareAccessor(fr, id, t, accessors, avail)
+	}
+
+	// funcStack to know if current function is one that should be skipped entirely (because it's invoked by `go` elsewhere)
+	type funcCtx struct {
+		fnObj     *types.Func
+		decl      *ast.FuncDecl // enclosing declaration, also for function literals
+		skipWhole bool
+	}
+	var funcStack []funcCtx
+
+	// Use astutil.Apply to walk with pre/post hooks (nodes are not replaced in the AST)
+	astutil.Apply(file,
+		// pre
+		func(c *astutil.Cursor) bool {
+			n := c.Node()
+			if n == nil {
+				return true
+			}
+
+			switch node := n.(type) {
+			case *ast.FuncDecl:
+				// entering a function decl: push new frame (inheriting nothing)
+				pushFrame(nil)
+
+				// determine if this function is one of the skipFuncs
+				var fnObj *types.Func
+				if node.Name != nil {
+					if obj := info.Defs[node.Name]; obj != nil {
+						if f, ok := obj.(*types.Func); ok {
+							fnObj = f
+						}
+					}
+				}
+				skip := fnObj != nil && skipFuncs[fnObj]
+				funcStack = append(funcStack, funcCtx{fnObj: fnObj, decl: node, skipWhole: skip})
+
+				// A receiver with a context accessor (-ctx-accessors) is a source; with
+				// -receiver-ctx, a context stored in the receiver is the last resort.
+				if node.Recv != nil {
+					for _, fld := range node.Recv.List {
+						for _, nm := range fld.Names {
+							if obj := info.Defs[nm]; obj != nil && nm.Name != "_" {
+								declareAccessor(currentFrame(), nm, obj.Type(), accessors, nm.Pos())
+								if flagReceiverCtx {
+									declareReceiver(currentFrame(), nm, obj.Type())
+								}
+							}
+						}
+					}
+				}
+
+				// Inspect params to fill baseline availability
+				for i, p := range declaredParams(info, node.Type) {
+					declare(p.name, p.typ, i == 0, p.name.Pos())
+				}
+				return true
+
+			case *ast.FuncLit:
+				// entering a function literal: a closure sees the enclosing scope, the
+				// body of `go func() {...}()` inherits nothing (see goSpan)
+				var enclosing funcCtx
+				if len(funcStack) > 0 {
+					enclosing = funcStack[len(funcStack)-1]
+				}
+				if goLits[node] {
+					pushFrame(nil)
+					enclosing.skipWhole = false
+				} else {
+					pushFrame(currentFrame())
+				}
+
+				// func literal params
+				for i, p := range declaredParams(info, node.Type) {
+					declare(p.name, p.typ, i == 0, p.name.Pos())
+				}
+				// For func literals, we can't easily map to a types.Func object for skipWhole detection.
+				// However, we already recorded anonymous goroutine bodies as skipRanges earlier.
+				funcStack = append(funcStack, funcCtx{fnObj: nil, decl: enclosing.decl, skipWhole: enclosing.skipWhole})
+				return true
+
+			case *ast.BlockStmt:
+				// push a child frame that inherits the parent frame
+				var copyFrom *scopeFrame
+				if cur := currentFrame(); cur != nil {
+					copyFrom = cur
+				}
+				pushFrame(copyFrom)
+				return true
+
+			case *ast.AssignStmt:
+				// handle `:=` new declarations, in scope after the statement
+				if node.Tok == token.DEFINE {
+					for i, lhs := range node.Lhs {
+						id, ok := lhs.(*ast.Ident)
+						if !ok || id == nil {
+							continue
+						}
+						if reassigned(info, node, i) {
+							continue
+						}
+						// Try to get the declared object's type via info.Defs (should be present for :=)
+						var t types.Type
+						if obj := info.Defs[id]; obj != nil {
+							t = obj.Type()
+						} else if tv := info.Types[id]; tv.Type != nil {
+							t = tv.Type
+						}
+						// as fallback, attempt to get type from the corresponding RHS expr (best-effort)
+						if t == nil {
+							// find index of id in Lhs to map rhs
+							for idx, lhsExpr := range node.Lhs {
+								if lhsExpr == id && idx < len(node.Rhs) {
+									if rhsT := info.TypeOf(node.Rhs[idx]); rhsT != nil {
+										t = rhsT
+									}
+									break
+								}
+							}
+						}
+						// `ctx := &context.Background()` does not type-check, but still
+						// declares a pointer
+						if !isValidType(t) && len(node.Lhs) == len(node.Rhs) {
+							if u, ok := node.Rhs[i].(*ast.UnaryExpr); ok && u.Op == token.AND && isValidType(info.TypeOf(u.X)) {
+								t = types.NewPointer(info.TypeOf(u.X))
+							}
+						}
+						declare(id, t, false, node.End())
+					}
+				}
+				return true
+
+			case *ast.ValueSpec:
+				// var declarations: var ctx context.Context or var ctx = something, in
+				// scope after the spec
+				for _, id := range node.Names {
+					if id == nil {
+						continue
+					}
+					var t types.Type
+					if obj := info.Defs[id]; obj != nil {
+						t = obj.Type()
+					} else if node.Type != nil {
+						if tv := info.TypeOf(node.Type); tv != nil {
+							t = tv
+						}
+					} else {
+						// try initializer
+						for _, val := range node.Values {
+							if tv := info.TypeOf(val); tv != nil {
+								t = tv
+								break
+							}
+						}
+					}
+					declare(id, t, false, node.End())
+				}
+				return true
+
+			case *ast.CallExpr:
+				// Skip cases:
+				//  - if the containing function is flagged skipWhole (because it is invoked via `go target(...)`)
+				//  - if this call is inside an anonymous goroutine body and -no-goroutines is set (skipRanges)
+				site := callSite{}
+				if len(funcStack) > 0 {
+					site.decl = funcStack[len(funcStack)-1].decl
+					site.skip = funcStack[len(funcStack)-1].skipWhole
+				}
+				if flagNoGoroutines && insideSkipRange(node.Lparen) {
+					site.skip = true
+				}
+				if fr := currentFrame(); fr != nil {
+					if srcs := fr.candidates(node.Pos()); len(srcs) > 0 {
+						site.ctxExpr, site.ctxField, site.ctxAvail = srcs[0].expr, srcs[0].field, srcs[0].availPos
+						for _, src := range srcs[1:] {
+							site.alternatives = append(site.alternatives, src.expr)
+						}
+					}
+				}
+				return visit(node, site)
+			}
+			return true
+		},
+		// post
+		func(c *astutil.Cursor) bool {
+			switch c.Node().(type) {
+			case *ast.BlockStmt:
+				popFrame()
+			case *ast.FuncDecl:
+				// pop funcStack and frame
+				if len(funcStack) > 0 {
+					funcStack = funcStack[:len(funcStack)-1]
+				}
+				popFrame()
+			case *ast.FuncLit:
+				if len(funcStack) > 0 {
+					funcStack = funcStack[:len(funcStack)-1]
+				}
+				popFrame()
+			}
+			return true
+		})
+}
+
+// reassigned reports whether the i-th name on the left of the := assign is an
+// existing variable it assigns a value of that variable's type to, rather than
+// a new one. In an ill-typed file, a name := cannot assign to is taken as new.
+func reassigned(info *types.Info, assign *ast.AssignStmt, i int) bool {
+	id := assign.Lhs[i].(*ast.Ident)
+	obj := info.Uses[id]
+	if info.Defs[id] != nil || obj == nil {
+		return false
+	}
+	var t types.Type
+	if len(assign.Lhs) == len(assign.Rhs) {
+		t = info.TypeOf(assign.Rhs[i])
+	} else if tuple, ok := info.TypeOf(assign.Rhs[0]).(*types.Tuple); ok && len(assign.Rhs) == 1 && i < tuple.Len() {
+		t = tuple.At(i).Type()
+	}
+	return t != nil && types.AssignableTo(t, obj.Type())
+}
+
+// declaredParam is a named parameter of a function declaration or literal.
+type declaredParam struct {
+	name  *ast.Ident
+	field *ast.Field
+	typ   types.Type // nil if unknown
+}
+
+// declaredParams returns the named parameters of ft in order. Unnamed parameters
+// declare nothing and are left out.
+func declaredParams(info *types.Info, ft *ast.FuncType) []declaredParam {
+	var params []declaredParam
+	if ft == nil || ft.Params == nil {
+		return nil
+	}
+	for _, fld := range ft.Params.List {
+		for _, nm := range fld.Names {
+			if nm == nil {
+				continue
+			}
+			// try to get the type from info.Defs (for param id) or Types map
+			var t types.Type
+			if obj := info.Defs[nm]; obj != nil {
+				t = obj.Type()
+			} else if tv := info.Types[nm]; tv.Type != nil {
+				t = tv.Type
+			}
+			if t == nil && fld.Type != nil {
+				// sometimes the type is on the field.Type (use typeOf expression)
+				t = info.TypeOf(fld.Type)
+			}
+			params = append(params, declaredParam{name: nm, field: fld, typ: t})
+		}
+	}
+	return params
+}
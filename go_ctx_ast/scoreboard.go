@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unownedEntry labels the files no CODEOWNERS entry matches.
+const unownedEntry = "(unowned)"
+
+// scoreRow is the migration progress under one CODEOWNERS entry (-scoreboard).
+type scoreRow struct {
+	entry     string // the entry's pattern, or unownedEntry
+	line      int    // the entry's line in CODEOWNERS, 0 for unownedEntry
+	owners    []string
+	remaining int            // context.TODO() calls left in the code
+	fixable   int            // of which the tool can rewrite
+	funcs     map[string]int // functions with no context in scope -> their context.TODO() calls
+}
+
+// buildScoreboard attributes the context.TODO() calls in results to the CODEOWNERS
+// entry owning their file (the last matching one). File names are made relative
+// to root, the directory CODEOWNERS patterns are relative to. Rows follow the
+// order of the CODEOWNERS file, unowned files last.
+func buildScoreboard(co *codeOwners, root string, results []fileResult) []*scoreRow {
+	rows := map[int]*scoreRow{}
+	row := func(filename string) *scoreRow {
+		rule, ok := ownerRule{}, false
+		if rel, err := filepath.Rel(root, filename); err == nil && !strings.HasPrefix(rel, "..") {
+			_, rule, ok = co.owners(filepath.ToSlash(rel))
+		}
+		key := rule.line
+		if !ok {
+			key = 0
+			rule = ownerRule{pattern: unownedEntry}
+		}
+		r := rows[key]
+		if r == nil {
+			r = &scoreRow{entry: rule.pattern, line: key, owners: rule.owners, funcs: map[string]int{}}
+			rows[key] = r
+		}
+		return r
+	}
+	for _, res := range results {
+		if len(res.findings)+len(res.inconsistent)+len(res.unresolved) == 0 {
+			continue
+		}
+		r := row(res.filename)
+		// a call can be fixable in one build configuration and unresolved in another
+		sites := map[int]bool{}
+		for _, f := range res.findings {
+			for _, pos := range f.todoSites() {
+				sites[pos.Offset] = true
+				r.fixable++
+			}
+		}
+		for _, f := range res.inconsistent {
+			for _, pos := range f.todoSites() {
+				sites[pos.Offset] = true
+			}
+		}
+		for _, u := range res.unresolved {
+			if u.reason == reasonSuppressed {
+				continue
+			}
+			sites[u.pos.Offset] = true
+			if u.reason == reasonNoContext && u.fn != "" {
+				r.funcs[u.fn]++
+			}
+		}
+		r.remaining += len(sites)
+	}
+
+	var sorted []*scoreRow
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].line, sorted[j].line
+		if (a == 0) != (b == 0) {
+			return b == 0
+		}
+		return a < b
+	})
+	return sorted
+}
+
+// sortedFuncs returns the functions of r needing a context parameter, those with
+// the most context.TODO() calls first.
+func (r *scoreRow) sortedFuncs() []string {
+	var fns []string
+	for fn := range r.funcs {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if r.funcs[fns[i]] != r.funcs[fns[j]] {
+			return r.funcs[fns[i]] > r.funcs[fns[j]]
+		}
+		return fns[i] < fns[j]
+	})
+	return fns
+}
+
+// writeScoreboardMarkdown writes rows as a Markdown table followed by the functions
+// each entry has to give a context parameter.
+func writeScoreboardMarkdown(w io.Writer, rows []*scoreRow) {
+	fmt.Fprintln(w, "| CODEOWNERS entry | Owners | Remaining | Auto-fixable | Functions needing ctx |")
+	fmt.Fprintln(w, "|---|---|---:|---:|---:|")
+	remaining, fixable, funcs := 0, 0, 0
+	for _, r := range rows {
+		fmt.Fprintf(w, "| `%s` | %s | %d | %d | %d |\n", r.entry, strings.Join(r.owners, " "), r.remaining, r.fixable, len(r.funcs))
+		remaining += r.remaining
+		fixable += r.fixable
+		funcs += len(r.funcs)
+	}
+	fmt.Fprintf(w, "| **Total** | | %d | %d | %d |\n", remaining, fixable, funcs)
+
+	for _, r := range rows {
+		if len(r.funcs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n### `%s`\n\n", r.entry)
+		for _, fn := range r.sortedFuncs() {
+			fmt.Fprintf(w, "- `%s` (%d)\n", fn, r.funcs[fn])
+		}
+	}
+}
+
+// writeScoreboardCSV writes one record per row; functions are joined with ";".
+func writeScoreboardCSV(w io.Writer, rows []*scoreRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"entry", "owners", "remaining", "auto_fixable", "functions_needing_ctx"})
+	for _, r := range rows {
+		cw.Write([]string{r.entry, strings.Join(r.owners, " "), strconv.Itoa(r.remaining), strconv.Itoa(r.fixable), strings.Join(r.sortedFuncs(), ";")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printScoreboard implements -scoreboard and returns the exit status.
+func printScoreboard(format string, results []fileResult) int {
+	root, err := repoRoot()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	co, err := loadCodeOwners(root, "")
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	// git reports the real path of the repository; compare real paths
+	for i := range results {
+		results[i].filename = realPath(results[i].filename)
+	}
+	rows := buildScoreboard(co, root, results)
+	switch format {
+	case "markdown":
+		writeScoreboardMarkdown(os.Stdout, rows)
+	case "csv":
+		if err := writeScoreboardCSV(os.Stdout, rows); err != nil {
+			log.Print(err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessFileUnresolved(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc"}, map[string]map[string]string{
+		"example.com/svc": {"svc.go": `package svc
+
+import "context"
+
+var background = context.TODO()
+
+type Server struct{}
+
+func (s *Server) Start() {
+	use(context.TODO())
+}
+
+func Run(ctx context.Context) {
+	use(context.TODO())
+	go func(ctx context.Context) {
+		use(context.TODO())
+	}(ctx)
+}
+
+func use(ctx context.Context) {}
+`},
+	})
+	flagNoGoroutines = true
+	defer func() { flagNoGoroutines = false }()
+
+	findings, unresolved := processFile(pkgs[0], pkgs[0].Syntax[0], nil, newFuncIndex(pkgs))
+	assert.Len(t, findings, 1)
+	var got []string
+	for _, u := range unresolved {
+		got = append(got, u.fn+": "+u.reason)
+	}
+	assert.Equal(t, []string{
+		": " + reasonNoContext,
+		"svc.(*Server).Start: " + reasonNoContext,
+		"svc.Run: " + reasonGoroutine,
+	}, got)
+}
+
+func TestScoreboard(t *testing.T) {
+	co, _, err := parseCodeOwners(strings.NewReader(`*     @org/platform
+/svc/ @org/svc
+`))
+	assert.NoError(t, err)
+	at := func(file string, offset int) token.Position {
+		return token.Position{Filename: file, Offset: offset}
+	}
+	results := []fileResult{
+		{
+			filename: "/repo/svc/a.go",
+			findings: []finding{{pos: at("/repo/svc/a.go", 10)}, {pos: at("/repo/svc/a.go", 20)}},
+			unresolved: []unresolvedSite{
+				{pos: at("/repo/svc/a.go", 30), fn: "svc.Start", reason: reasonNoContext},
+				{pos: at("/repo/svc/a.go", 40), fn: "svc.Start", reason: reasonNoContext},
+				{pos: at("/repo/svc/a.go", 50), fn: "svc.Stop", reason: reasonGoroutine},
+			},
+		},
+		{
+			filename:     "/repo/svc/b.go",
+			inconsistent: []finding{{pos: at("/repo/svc/b.go", 10)}},
+			unresolved:   []unresolvedSite{{pos: at("/repo/svc/b.go", 10), fn: "svc.Other", reason: reasonNoContext}},
+		},
+		{filename: "/repo/main.go", findings: []finding{{pos: at("/repo/main.go", 5)}}},
+		{filename: "/repo/clean.go"},
+		{filename: "/elsewhere/x.go", unresolved: []unresolvedSite{{pos: at("/elsewhere/x.go", 1), reason: reasonNoContext}}},
+	}
+	rows := buildScoreboard(co, "/repo", results)
+
+	var md bytes.Buffer
+	writeScoreboardMarkdown(&md, rows)
+	assert.Equal(t, "| CODEOWNERS entry | Owners | Remaining | Auto-fixable | Functions needing ctx |\n"+
+		"|---|---|---:|---:|---:|\n"+
+		"| `*` | @org/platform | 1 | 1 | 0 |\n"+
+		"| `/svc/` | @org/svc | 6 | 2 | 2 |\n"+
+		"| `(unowned)` |  | 1 | 0 | 0 |\n"+
+		"| **Total** | | 8 | 3 | 2 |\n"+
+		"\n### `/svc/`\n\n"+
+		"- `svc.Start` (2)\n"+
+		"- `svc.Other` (1)\n", md.String())
+
+	var csv bytes.Buffer
+	assert.NoError(t, writeScoreboardCSV(&csv, rows))
+	assert.Equal(t, `entry,owners,remaining,auto_fixable,functions_needing_ctx
+*,@org/platform,1,1,
+/svc/,@org/svc,6,2,svc.Start;svc.Other
+(unowned),,1,0,
+`, csv.String())
+}
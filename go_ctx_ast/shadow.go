@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// runShadowLint implements -lint-shadow-ctx and returns the exit status: 1 if any
+// context is shadowed and -fix was not given.
+func runShadowLint(configs []buildConfig, patterns []string, changed changedLines) int {
+	pkgs, status := loadWholeProgram("-lint-shadow-ctx", configs, patterns, false)
+	if pkgs == nil {
+		return status
+	}
+	reports, results := lintShadowedContexts(pkgs)
+	if changed != nil {
+		reports = changed.filter(reports)
+	}
+	for _, r := range reports {
+		fmt.Printf("[LINT] %s:%d: %s\n", r.pos.Filename, r.pos.Line, r.text)
+	}
+	if !flagFix {
+		if len(reports) > 0 {
+			return 1
+		}
+		return 0
+	}
+	return applyResults(results)
+}
+
+// lintShadowedContexts reports the classic
+//
+//	if slow {
+//		ctx, cancel := context.WithTimeout(ctx, time.Second)
+//		defer cancel()
+//	}
+//	query(ctx) // not bounded
+//
+// a := in an inner block declaring a context variable that shadows one of the
+// enclosing function, where the shadowed variable is still used after the block.
+// The fix assigns to the outer variable instead, declaring the statement's other
+// new variables first:
+//
+//	var cancel context.CancelFunc
+//	ctx, cancel = context.WithTimeout(ctx, time.Second)
+//
+// In a loop, where assigning to the outer variable would carry the inner value
+// into the next iteration, the shadowing is reported without a fix.
+func lintShadowedContexts(pkgs []*packages.Package) ([]finding, []fileResult) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	es := newEditSet(pkgs[0].Fset)
+	var reports []finding
+	for _, u := range migrationUnits(pkgs) {
+		reports = append(reports, shadowedContexts(&es, u.pkg, u.file)...)
+	}
+	sortFindings(reports)
+	return reports, es.results()
+}
+
+// shadowedContexts reports the shadowing := of file and records their fixes in es.
+func shadowedContexts(es *editSet, pkg *packages.Package, file *ast.File) []finding {
+	info := pkg.TypesInfo
+	var reports []finding
+	inList := map[ast.Stmt]bool{} // statements of a block or clause body
+	var stack []ast.Node          // enclosing nodes, innermost last
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			markList(inList, n.List)
+		case *ast.CaseClause:
+			markList(inList, n.Body)
+		case *ast.CommClause:
+			markList(inList, n.Body)
+		case *ast.AssignStmt:
+			fn := innermostFunc(stack)
+			if n.Tok != token.DEFINE || fn == nil {
+				return true
+			}
+			var found []finding
+			loop := false
+			for _, lhs := range n.Lhs {
+				id, ok := lhs.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				outer, used := shadowedBy(info, fn, id)
+				if outer == nil {
+					continue
+				}
+				advice := "assign it with = instead"
+				if inLoopAfter(stack, outer) {
+					advice = "it is in a loop, where assigning it with = would carry the value into the next iteration"
+					loop = true
+				}
+				decl := es.fset.Position(outer.Pos())
+				found = append(found, finding{pos: es.fset.Position(id.Pos()), text: fmt.Sprintf(
+					"%s := shadows the %s declared at %s:%d, which is used after this block at line %d; %s",
+					id.Name, id.Name, filepath.Base(decl.Filename), decl.Line, es.fset.Position(used).Line, advice)})
+			}
+			if len(found) == 0 {
+				return true
+			}
+			why := "in a loop"
+			if !loop {
+				why = fixShadowing(es, pkg, file, fn, n, inList[n])
+			}
+			if why != "" {
+				log.Printf("[SKIP] %s:%d: not fixed: %s", found[0].pos.Filename, found[0].pos.Line, why)
+			}
+			reports = append(reports, found...)
+		}
+		return true
+	})
+	return reports
+}
+
+// innermostFunc returns the last function declaration or literal of stack, or nil.
+func innermostFunc(stack []ast.Node) ast.Node {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch stack[i].(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			return stack[i]
+		}
+	}
+	return nil
+}
+
+// inLoopAfter reports whether the innermost node of stack is in a loop entered
+// after outer is declared.
+func inLoopAfter(stack []ast.Node, outer types.Object) bool {
+	for _, n := range stack {
+		switch n.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			if n.Pos() > outer.Pos() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func markList(inList map[ast.Stmt]bool, list []ast.Stmt) {
+	for _, s := range list {
+		inList[s] = true
+	}
+}
+
+// shadowedBy returns the context variable of fn that the := declaration id
+// shadows and its first use after id's scope, or nil if id declares nothing, or
+// nothing that matters: the outer variable is not a context, belongs to another
+// function, or is not used after the inner block.
+func shadowedBy(info *types.Info, fn ast.Node, id *ast.Ident) (types.Object, token.Pos) {
+	obj, ok := info.Defs[id].(*types.Var)
+	if !ok || !isContextValue(obj.Type()) || obj.Parent() == nil || obj.Parent().Parent() == nil {
+		return nil, token.NoPos
+	}
+	inner := obj.Parent()
+	_, outer := inner.Parent().LookupParent(id.Name, id.Pos())
+	if v, ok := outer.(*types.Var); !ok || !isContextValue(v.Type()) || outer.Pos() < fn.Pos() || outer.Pos() >= fn.End() {
+		return nil, token.NoPos
+	}
+	used := token.NoPos
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if use, ok := n.(*ast.Ident); ok && use.Pos() > inner.End() && info.Uses[use] == outer {
+			used = use.Pos()
+		}
+		return !used.IsValid()
+	})
+	if !used.IsValid() {
+		return nil, token.NoPos
+	}
+	return outer, used
+}
+
+// isContextValue reports whether t is exactly context.Context.
+func isContextValue(t types.Type) bool {
+	kind, ok := isContextType(t)
+	return ok && kind == ctxValue
+}
+
+// fixShadowing turns the := of assign into =, declaring the other variables it
+// declares in a var statement before it, or says why it cannot. Declarations can
+// only be added if assign is an element of a statement list.
+func fixShadowing(es *editSet, pkg *packages.Package, file *ast.File, fn ast.Node, assign *ast.AssignStmt, inList bool) string {
+	info := pkg.TypesInfo
+	var decls []string
+	for _, lhs := range assign.Lhs {
+		id, ok := lhs.(*ast.Ident)
+		if !ok || id.Name == "_" {
+			continue
+		}
+		obj := info.Defs[id]
+		if obj == nil {
+			continue // assigned, not declared
+		}
+		if outer, _ := shadowedBy(info, fn, id); outer != nil {
+			continue
+		}
+		typ, ok := typeString(obj.Type(), pkg.Types, file)
+		if !ok {
+			return "the type of " + id.Name + " is not spelled with the file's imports"
+		}
+		decls = append(decls, "var "+id.Name+" "+typ)
+	}
+	var insert edit
+	if len(decls) > 0 {
+		if !inList {
+			return "the other variables it declares cannot be declared before it"
+		}
+		src, err := os.ReadFile(es.fset.File(assign.Pos()).Name())
+		if err != nil {
+			return err.Error()
+		}
+		start := es.offset(assign.Pos())
+		indent, ok := lineIndent(src, start)
+		if !ok {
+			return "it shares its line with other code"
+		}
+		insert = edit{start: start, end: start, text: strings.Join(decls, "\n"+indent) + "\n" + indent}
+	}
+	var lhs []string
+	for _, e := range assign.Lhs {
+		lhs = append(lhs, types.ExprString(e))
+	}
+	text := strings.Join(lhs, ", ") + " = instead of :="
+	if len(decls) > 0 {
+		text = strings.Join(decls, "; ") + "; " + text
+		es.note(assign.Pos(), "", insert)
+	}
+	tok := es.offset(assign.TokPos)
+	es.note(assign.Pos(), text, edit{start: tok, end: tok + len(":="), text: "="})
+	return ""
+}
+
+// typeString spells t in file of package pkg, if the packages it refers to are
+// imported under a usable name.
+func typeString(t types.Type, pkg *types.Package, file *ast.File) (string, bool) {
+	ok := true
+	s := types.TypeString(t, func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		name, found := importName(file, p.Path())
+		if !found {
+			ok = false
+		}
+		return name
+	})
+	return s, ok
+}
+
+// lineIndent returns the whitespace before offset on its line, and whether there
+// is nothing else before it.
+func lineIndent(src []byte, offset int) (string, bool) {
+	start := offset
+	for start > 0 && src[start-1] != '\n' {
+		start--
+	}
+	indent := string(src[start:offset])
+	return indent, strings.TrimLeft(indent, " \t") == ""
+}
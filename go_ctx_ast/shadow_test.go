@@ -0,0 +1,101 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintShadowedContexts(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "svc.go")
+	src := `package svc
+
+import (
+	"context"
+	"time"
+)
+
+func Query(ctx context.Context, slow bool) {
+	if slow {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		use(ctx)
+	}
+	use(ctx)
+}
+
+func Scoped(ctx context.Context) {
+	for i := 0; i < 3; i++ {
+		ctx := context.WithValue(ctx, "i", i)
+		use(ctx)
+	}
+}
+
+func Retry(ctx context.Context) {
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		use(ctx)
+	}
+	use(ctx)
+}
+
+func Init(ctx context.Context, slow bool) {
+	if ctx, cancel := context.WithCancel(ctx); slow {
+		defer cancel()
+		use(ctx)
+	}
+	go func() {
+		ctx := context.Background()
+		use(ctx)
+	}()
+	use(ctx)
+}
+
+func use(ctx context.Context) {}
+`
+	assert.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc"}, map[string]map[string]string{
+		"example.com/svc": {filename: src},
+	})
+
+	reports, results := lintShadowedContexts(pkgs)
+	var texts []string
+	for _, r := range reports {
+		texts = append(texts, r.text)
+	}
+	assert.Equal(t, []string{
+		"ctx := shadows the ctx declared at svc.go:8, which is used after this block at line 14; assign it with = instead",
+		"ctx := shadows the ctx declared at svc.go:24, which is used after this block at line 30; it is in a loop, where assigning it with = would carry the value into the next iteration",
+		"ctx := shadows the ctx declared at svc.go:33, which is used after this block at line 42; assign it with = instead",
+	}, texts)
+
+	if !assert.Len(t, results, 1) {
+		return
+	}
+	var edits []edit
+	for _, f := range results[0].findings {
+		edits = append(edits, f.edit)
+	}
+	out, err := applyEdits([]byte(src), edits)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `	if slow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		use(ctx)
+	}
+	use(ctx)`)
+	// the init statement of an if has no room for declarations
+	assert.Contains(t, string(out), "	if ctx, cancel := context.WithCancel(ctx); slow {")
+	// in a loop, the shadowing is left for the author; a goroutine detaching
+	// from the outer context is not shadowing it
+	assert.Contains(t, string(out), `	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)`)
+	assert.Contains(t, string(out), `	go func() {
+		ctx := context.Background()`)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ownerGroup is a set of changed files with the same owners.
+type ownerGroup struct {
+	owners []string // sorted; empty for unowned files
+	files  []string // root-relative, slash-separated, sorted
+}
+
+// branchName returns the branch for the chunk-th part (1-based, 0 if the group
+// is not split) of g under prefix.
+func (g ownerGroup) branchName(prefix string, chunk int) string {
+	slug := "unowned"
+	if len(g.owners) > 0 {
+		var parts []string
+		for _, o := range g.owners {
+			parts = append(parts, branchUnsafe.ReplaceAllString(strings.TrimPrefix(o, "@"), "-"))
+		}
+		slug = strings.Join(parts, "+")
+	}
+	name := strings.TrimSuffix(prefix, "/") + "/" + slug
+	if chunk > 0 {
+		name += fmt.Sprintf("-%d", chunk)
+	}
+	return name
+}
+
+var branchUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// groupByOwners groups files by their CODEOWNERS owner set. Groups are ordered
+// by owners, unowned files last.
+func groupByOwners(co *codeOwners, files []string) []ownerGroup {
+	byKey := map[string]*ownerGroup{}
+	for _, f := range files {
+		owners, _, _ := co.owners(f)
+		owners = append([]string(nil), owners...)
+		sort.Strings(owners)
+		owners = slices.Compact(owners)
+		key := strings.Join(owners, " ")
+		g, ok := byKey[key]
+		if !ok {
+			g = &ownerGroup{owners: owners}
+			byKey[key] = g
+		}
+		g.files = append(g.files, f)
+	}
+	var groups []ownerGroup
+	for _, g := range byKey {
+		sort.Strings(g.files)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i].owners, groups[j].owners
+		if (len(a) == 0) != (len(b) == 0) {
+			return len(b) == 0
+		}
+		return strings.Join(a, " ") < strings.Join(b, " ")
+	})
+	return groups
+}
+
+// runSplit implements `go_ctx_ast split`: it groups the Go files changed since HEAD
+// by CODEOWNERS owner set and creates one branch with one commit per group, so a
+// large rewrite lands as reviewable pull requests. The commits are built with a
+// temporary index, so the working tree, the index and the current branch are left
+// as they are.
+func runSplit(args []string) int {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	codeOwnersPath := fs.String("codeowners", "", "CODEOWNERS file (default: where GitHub looks for it)")
+	message := fs.String("m", "Replace context.TODO() with the context in scope", "Commit message")
+	maxFiles := fs.Int("max-files", 0, "Split groups with more files than this into several branches (0: no limit)")
+	dryRun := fs.Bool("dry-run", false, "Print the plan but do not create branches")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s split [flags] <branch-prefix>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	prefix := fs.Arg(0)
+
+	out, err := gitOutput("rev-parse", "--show-toplevel")
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	root := strings.TrimSpace(string(out))
+	co, err := loadCodeOwners(root, *codeOwnersPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	out, err = gitOutput("diff", "--name-only", "-z", "HEAD", "--")
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	var files []string
+	for _, name := range strings.Split(strings.TrimRight(string(out), "\x00"), "\x00") {
+		if strings.HasSuffix(name, ".go") {
+			files = append(files, name)
+		} else if name != "" {
+			log.Printf("[SKIP] %s: not a Go file, left out of the split", name)
+		}
+	}
+	if len(files) == 0 {
+		log.Print("no changed Go files")
+		return 0
+	}
+
+	status := 0
+	for _, g := range groupByOwners(co, files) {
+		chunks := [][]string{g.files}
+		if *maxFiles > 0 && len(g.files) > *maxFiles {
+			chunks = nil
+			for i := 0; i < len(g.files); i += *maxFiles {
+				chunks = append(chunks, g.files[i:min(i+*maxFiles, len(g.files))])
+			}
+		}
+		owners := strings.Join(g.owners, " ")
+		if owners == "" {
+			owners = "(unowned)"
+		}
+		for i, chunk := range chunks {
+			n := 0
+			if len(chunks) > 1 {
+				n = i + 1
+			}
+			branch := g.branchName(prefix, n)
+			if *dryRun {
+				fmt.Printf("[DRY] %s: %d files, owners %s\n", branch, len(chunk), owners)
+				for _, f := range chunk {
+					fmt.Printf("    %s\n", f)
+				}
+				continue
+			}
+			if err := commitBranch(root, branch, *message, chunk); err != nil {
+				log.Printf("[ERROR] %s: %v", branch, err)
+				status = 1
+				continue
+			}
+			fmt.Printf("✅ %s: %d files, owners %s\n", branch, len(chunk), owners)
+		}
+	}
+	return status
+}
+
+// commitBranch creates branch at a new commit on top of HEAD that takes files, as
+// they are in the working tree, and nothing else. It fails if branch exists.
+func commitBranch(root, branch, message string, files []string) error {
+	tmp, err := os.MkdirTemp("", "go_ctx_ast-split")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}
+
+	if _, err := gitRun(root, env, nil, "read-tree", "HEAD"); err != nil {
+		return err
+	}
+	args := append([]string{"update-index", "--add", "--remove", "--"}, files...)
+	if _, err := gitRun(root, env, nil, args...); err != nil {
+		return err
+	}
+	tree, err := gitRun(root, env, nil, "write-tree")
+	if err != nil {
+		return err
+	}
+	commit, err := gitRun(root, nil, strings.NewReader(message), "commit-tree", string(bytes.TrimSpace(tree)), "-p", "HEAD", "-F", "-")
+	if err != nil {
+		return err
+	}
+	_, err = gitRun(root, nil, nil, "branch", branch, string(bytes.TrimSpace(commit)))
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSplit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	git := func(args ...string) string {
+		out, err := gitRun(dir, nil, nil, args...)
+		assert.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+	}
+
+	git("init", "-q", "-b", "main")
+	write(".github/CODEOWNERS", "/api/ @org/api\n/store/ @org/store @alice\n")
+	for _, name := range []string{"api/a.go", "api/b.go", "store/s.go", "main.go", "README.md"} {
+		write(name, "before\n")
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "base")
+	head := git("rev-parse", "HEAD")
+	for _, name := range []string{"api/a.go", "api/b.go", "store/s.go", "main.go", "README.md"} {
+		write(name, "after "+name+"\n")
+	}
+	status := git("status", "--porcelain")
+
+	assert.Equal(t, 0, runSplit([]string{"-m", "Use the context in scope", "ctx"}))
+	branches := map[string][]string{
+		"ctx/org-api":         {"api/a.go", "api/b.go"},
+		"ctx/alice+org-store": {"store/s.go"},
+		"ctx/unowned":         {"main.go"},
+	}
+	assert.Equal(t, "ctx/alice+org-store\nctx/org-api\nctx/unowned\nmain", git("branch", "--format=%(refname:short)"))
+	for branch, files := range branches {
+		assert.Equal(t, head, git("rev-parse", branch+"^"), branch)
+		assert.Equal(t, "Use the context in scope", git("log", "-1", "--format=%s", branch), branch)
+		assert.Equal(t, strings.Join(files, "\n"), git("diff", "--name-only", head, branch), branch)
+		for _, f := range files {
+			assert.Equal(t, "after "+f, git("show", branch+":"+f), branch)
+		}
+	}
+	// the working tree, the index and the current branch are left as they are
+	assert.Equal(t, status, git("status", "--porcelain"))
+	assert.Equal(t, "main", git("rev-parse", "--abbrev-ref", "HEAD"))
+
+	// existing branches are not overwritten
+	assert.Equal(t, 1, runSplit([]string{"ctx"}))
+
+	assert.Equal(t, 0, runSplit([]string{"-max-files", "1", "small"}))
+	assert.Equal(t, "api/a.go", git("diff", "--name-only", head, "small/org-api-1"))
+	assert.Equal(t, "api/b.go", git("diff", "--name-only", head, "small/org-api-2"))
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// structCtx implements -lint-struct-ctx. It reports struct types that store a
+// context.Context, which the context package advises against: "Do not store
+// Contexts inside a struct type; instead, pass a Context explicitly to each
+// function that needs it."
+//
+// With -fix, every method reading such a field through its receiver takes the
+// context as its first parameter instead, callers pass the context they have in
+// scope, and the field is removed once nothing else reads it.
+//
+// As in ptrMigration, objects are keyed by declaring position because test
+// variants of a package declare distinct objects for the same source.
+type structCtx struct {
+	editSet
+
+	fields       map[token.Position]*ctxField // context.Context fields of named structs
+	methods      map[funcKey]*ctxMethod       // methods reading one of them through the receiver
+	ifaceMethods map[string]bool              // names of interface methods, declared or imported
+	excluded     map[funcKey]string           // methods left alone, with the reason
+	params       map[token.Position]bool      // parameter declarations
+
+	reports []finding // one per field, the lint output
+	args    []argUse  // identifiers the inserted call arguments refer to
+}
+
+// ctxField is a struct field of type context.Context.
+type ctxField struct {
+	strct string
+	name  string
+	decl  *ast.Field // nil for an embedded context.Context
+	owner *ast.StructType
+	kept  string // why the field stays; empty while it can still be removed
+}
+
+// ctxMethod is a method that reads a context field through its receiver.
+type ctxMethod struct {
+	unit   migrationUnit
+	decl   *ast.FuncDecl
+	field  token.Position
+	reads  []*ast.SelectorExpr
+	param  string // the parameter the context is passed in
+	insert bool   // the parameter has to be added
+}
+
+// argUse is a variable an inserted call argument refers to.
+type argUse struct {
+	pkg  *types.Package
+	pos  token.Pos
+	name string
+}
+
+// runStructCtxLint implements -lint-struct-ctx and returns the exit status: 1 if
+// any struct stores a context and -fix was not given. Without -fix, reports can be
+// limited to changed lines (-since).
+func runStructCtxLint(configs []buildConfig, patterns []string, changed changedLines) int {
+	pkgs, status := loadWholeProgram("-lint-struct-ctx", configs, patterns, flagFix)
+	if pkgs == nil {
+		return status
+	}
+	reports, results := lintStructContexts(pkgs, flagFix)
+	if changed != nil {
+		reports = changed.filter(reports)
+	}
+	for _, r := range reports {
+		fmt.Printf("[LINT] %s:%d: %s\n", r.pos.Filename, r.pos.Line, r.text)
+	}
+	if !flagFix {
+		if len(reports) > 0 {
+			return 1
+		}
+		return 0
+	}
+	return applyResults(results)
+}
+
+// lintStructContexts reports the context fields of the structs in pkgs, which must
+// all come from one loadPackages call, and with fix computes the edits of the fix.
+func lintStructContexts(pkgs []*packages.Package, fix bool) ([]finding, []fileResult) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	m := &structCtx{
+		editSet:      newEditSet(pkgs[0].Fset),
+		fields:       map[token.Position]*ctxField{},
+		methods:      map[funcKey]*ctxMethod{},
+		ifaceMethods: importedInterfaceMethods(pkgs),
+		excluded:     map[funcKey]string{},
+		params:       map[token.Position]bool{},
+	}
+	units := migrationUnits(pkgs)
+	for _, u := range units {
+		m.collect(u)
+	}
+	sort.Slice(m.reports, func(i, j int) bool { return positionLess(m.reports[i].pos, m.reports[j].pos) })
+	if !fix {
+		return m.reports, nil
+	}
+
+	for _, u := range units {
+		m.collectMethods(u)
+	}
+	for _, u := range units {
+		m.excludeMethodValues(u.pkg.TypesInfo, u.file)
+	}
+	m.reportExcluded()
+
+	for key, meth := range m.methods {
+		if _, skip := m.excluded[key]; !skip {
+			m.rewriteMethod(meth)
+		}
+	}
+	for _, u := range units {
+		m.rewriteCalls(u)
+	}
+	m.removeFields(units)
+	m.removeImports(units)
+	return m.reports, m.results()
+}
+
+func (m *structCtx) pos(id *ast.Ident) token.Position {
+	return m.fset.Position(id.Pos())
+}
+
+// collect records the context fields of named struct types, the interface
+// methods and the parameters declared in file.
+func (m *structCtx) collect(u migrationUnit) {
+	info := u.pkg.TypesInfo
+	ast.Inspect(u.file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.TypeSpec:
+			st, ok := node.Type.(*ast.StructType)
+			obj := info.Defs[node.Name]
+			if !ok || obj == nil {
+				return true
+			}
+			for _, f := range contextFields(obj.Type()) {
+				p := m.fset.Position(f.Pos())
+				if _, seen := m.fields[p]; seen {
+					continue
+				}
+				field := &ctxField{strct: node.Name.Name, name: f.Name(), owner: st, kept: "it is embedded"}
+				for _, fld := range st.Fields.List {
+					for _, nm := range fld.Names {
+						if nm.Pos() == f.Pos() {
+							field.decl, field.kept = fld, ""
+						}
+					}
+				}
+				m.fields[p] = field
+				m.reports = append(m.reports, finding{pos: p, text: fmt.Sprintf("%s stores a context.Context in field %s; pass it to the methods that need it instead", node.Name.Name, f.Name())})
+			}
+		case *ast.InterfaceType:
+			for _, meth := range node.Methods.List {
+				for _, nm := range meth.Names {
+					m.ifaceMethods[nm.Name] = true
+				}
+			}
+		case *ast.FuncType:
+			if node.Params != nil {
+				for _, fld := range node.Params.List {
+					for _, nm := range fld.Names {
+						m.params[m.pos(nm)] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// importedInterfaceMethods returns the method names of the interfaces declared in
+// the packages pkgs import: adding a parameter to a method of the same name could
+// break an implementation of one of them (io.Closer, fmt.Stringer, ...).
+func importedInterfaceMethods(pkgs []*packages.Package) map[string]bool {
+	names := map[string]bool{}
+	seen := map[*types.Package]bool{}
+	var visit func(p *types.Package)
+	visit = func(p *types.Package) {
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		scope := p.Scope()
+		for _, name := range scope.Names() {
+			if tn, ok := scope.Lookup(name).(*types.TypeName); ok {
+				if iface, ok := tn.Type().Underlying().(*types.Interface); ok {
+					for i := 0; i < iface.NumMethods(); i++ {
+						names[iface.Method(i).Name()] = true
+					}
+				}
+			}
+		}
+		for _, imp := range p.Imports() {
+			visit(imp)
+		}
+	}
+	for _, pkg := range pkgs {
+		for _, imp := range pkg.Types.Imports() {
+			visit(imp)
+		}
+	}
+	return names
+}
+
+// collectMethods finds the methods in file that read a context field through
+// their receiver and decides how the context is passed to them.
+func (m *structCtx) collectMethods(u migrationUnit) {
+	info := u.pkg.TypesInfo
+	for _, decl := range u.file.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Recv == nil || fd.Body == nil || len(fd.Recv.List[0].Names) == 0 {
+			continue
+		}
+		fn, _ := info.Defs[fd.Name].(*types.Func)
+		recv := info.Defs[fd.Recv.List[0].Names[0]]
+		if fn == nil || recv == nil {
+			continue
+		}
+		meth := &ctxMethod{unit: u, decl: fd}
+		key := declKey(m.fset, fn)
+		var stack []ast.Node
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			if n == nil {
+				stack = stack[:len(stack)-1]
+				return true
+			}
+			stack = append(stack, n)
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			x, ok := sel.X.(*ast.Ident)
+			if !ok || info.Uses[x] != recv {
+				return true
+			}
+			obj := info.Uses[sel.Sel]
+			if obj == nil {
+				return true
+			}
+			fp := m.fset.Position(obj.Pos())
+			if _, ok := m.fields[fp]; !ok {
+				return true
+			}
+			switch p := stack[len(stack)-2].(type) {
+			case *ast.AssignStmt:
+				for _, lhs := range p.Lhs {
+					if lhs == sel {
+						return true // a write; removed together with the field
+					}
+				}
+			case *ast.UnaryExpr:
+				if p.Op == token.AND {
+					m.excluded[key] = "it takes the address of " + types.ExprString(sel)
+				}
+			}
+			if meth.field.IsValid() && meth.field != fp {
+				m.excluded[key] = "it reads more than one context field"
+			}
+			meth.field = fp
+			meth.reads = append(meth.reads, sel)
+			return true
+		})
+		if len(meth.reads) == 0 {
+			continue
+		}
+		m.methods[key] = meth
+
+		if m.ifaceMethods[fd.Name.Name] {
+			m.excluded[key] = "method " + fd.Name.Name + " may implement an interface"
+			continue
+		}
+		params := fn.Type().(*types.Signature).Params()
+		if params.Len() > 0 {
+			if kind, ok := isContextType(params.At(0).Type()); ok && kind == ctxValue {
+				meth.param = params.At(0).Name()
+				if meth.param == "" || meth.param == "_" {
+					m.excluded[key] = "its context parameter is unnamed"
+					continue
+				}
+				for _, sel := range meth.reads {
+					if _, obj := u.pkg.Types.Scope().Innermost(sel.Pos()).LookupParent(meth.param, sel.Pos()); obj != params.At(0) {
+						m.excluded[key] = meth.param + " is shadowed where " + types.ExprString(sel) + " is read"
+					}
+				}
+				continue
+			}
+		}
+		meth.param, meth.insert = "ctx", true
+		if declaresName(info, fd, "ctx") {
+			m.excluded[key] = "it already uses the name ctx"
+		}
+	}
+}
+
+// declaresName reports whether fd declares or refers to a variable, constant,
+// function, type or package called name; fields and methods do not count.
+func declaresName(info *types.Info, fd *ast.FuncDecl, name string) bool {
+	found := false
+	ast.Inspect(fd, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Name != name {
+			return !found
+		}
+		obj := info.Defs[id]
+		if obj == nil {
+			obj = info.Uses[id]
+		}
+		switch o := obj.(type) {
+		case nil:
+		case *types.Var:
+			found = found || !o.IsField()
+		case *types.Func:
+			found = found || o.Type().(*types.Signature).Recv() == nil
+		default:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// excludeMethodValues excludes methods that are referenced other than by a direct
+// call on a value: their signature may have to match a function type elsewhere.
+func (m *structCtx) excludeMethodValues(info *types.Info, file *ast.File) {
+	called := map[*ast.Ident]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr); ok && !info.Types[sel.X].IsType() {
+				called[sel.Sel] = true
+			}
+		}
+		return true
+	})
+	ast.Inspect(file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || called[id] {
+			return true
+		}
+		if fn, ok := info.Uses[id].(*types.Func); ok {
+			key := declKey(m.fset, fn)
+			if _, ok := m.methods[key]; ok {
+				m.excluded[key] = "used as a method value or expression"
+			}
+		}
+		return true
+	})
+}
+
+func (m *structCtx) reportExcluded() {
+	var skipped []funcKey
+	for key := range m.excluded {
+		skipped = append(skipped, key)
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].less(skipped[j]) })
+	for _, key := range skipped {
+		meth := m.methods[key]
+		log.Printf("[SKIP] %s:%d: %s keeps reading %s: %s", key.filename, key.line, key.name, types.ExprString(meth.reads[0]), m.excluded[key])
+	}
+}
+
+// migrated returns the method fn is being changed into taking its context as a
+// parameter, or nil.
+func (m *structCtx) migrated(fn *types.Func) *ctxMethod {
+	key := declKey(m.fset, fn)
+	if _, skip := m.excluded[key]; skip {
+		return nil
+	}
+	return m.methods[key]
+}
+
+// rewriteMethod adds the context parameter to meth and reads it instead of the field.
+func (m *structCtx) rewriteMethod(meth *ctxMethod) {
+	fd := meth.decl
+	if meth.insert {
+		q := m.contextName(meth.unit.file)
+		text := "ctx " + q + ".Context"
+		at := m.offset(fd.Type.Params.Opening) + 1
+		if len(fd.Type.Params.List) > 0 {
+			at = m.offset(fd.Type.Params.List[0].Pos())
+			text += ", "
+		}
+		m.note(fd.Name.Pos(), fmt.Sprintf("%s: added parameter ctx %s.Context", fd.Name.Name, q), edit{start: at, end: at, text: text})
+	}
+	for _, sel := range meth.reads {
+		m.note(sel.Pos(), types.ExprString(sel)+" → "+meth.param, edit{start: m.offset(sel.Pos()), end: m.offset(sel.End()), text: meth.param})
+	}
+}
+
+// rewriteCalls passes a context to every call of a migrated method in u: the
+// caller's own context parameter if it is migrated too, else the context in
+// scope, else the field the method used to read.
+func (m *structCtx) rewriteCalls(u migrationUnit) {
+	info := u.pkg.TypesInfo
+	walkScopes(info, u.file, false, func(call *ast.CallExpr, site callSite) bool {
+		id := calleeIdent(call)
+		if id == nil {
+			return true
+		}
+		fn, ok := info.Uses[id].(*types.Func)
+		if !ok {
+			return true
+		}
+		meth := m.migrated(fn)
+		if meth == nil {
+			return true
+		}
+		field := m.fields[meth.field]
+
+		var text string
+		if site.decl != nil {
+			if caller, ok := info.Defs[site.decl.Name].(*types.Func); ok {
+				if cm := m.migrated(caller); cm != nil {
+					text = cm.param
+				}
+			}
+		}
+		if text == "" && site.ctxExpr != "" {
+			if _, fromField := m.fields[m.fset.Position(site.ctxField)]; !site.ctxField.IsValid() || !fromField {
+				text = site.ctxExpr
+			}
+		}
+		if text == "" {
+			sel, _ := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+			if sel != nil && isPlainRef(sel.X) && (token.IsExported(field.name) || fn.Pkg().Path() == u.pkg.Types.Path()) {
+				text = types.ExprString(sel.X) + "." + field.name
+				if field.kept == "" {
+					p := m.fset.Position(call.Pos())
+					field.kept = fmt.Sprintf("it is passed on by a caller without a context at %s:%d", p.Filename, p.Line)
+				}
+			} else {
+				text = m.contextName(u.file) + ".TODO()"
+			}
+		}
+		m.args = append(m.args, argUse{pkg: u.pkg.Types, pos: call.Pos(), name: leadingIdent(text)})
+
+		at := m.offset(call.Lparen) + 1
+		arg := text
+		if len(call.Args) > 0 {
+			at = m.offset(call.Args[0].Pos())
+			arg += ", "
+		}
+		m.note(call.Pos(), fmt.Sprintf("%s(...) → passes %s", types.ExprString(call.Fun), text), edit{start: at, end: at, text: arg})
+		return true
+	})
+}
+
+// isPlainRef reports whether e is an identifier or a chain of field selections,
+// which can be evaluated a second time without side effects.
+func isPlainRef(e ast.Expr) bool {
+	switch x := ast.Unparen(e).(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		return isPlainRef(x.X)
+	}
+	return false
+}
+
+// leadingIdent returns the identifier an expression like "r.Context()" starts with.
+func leadingIdent(expr string) string {
+	if i := strings.IndexAny(expr, ".()"); i >= 0 {
+		return expr[:i]
+	}
+	return expr
+}
+
+// removeFields deletes the context fields that nothing reads any more, together
+// with the keyed composite literal entries and plain assignments that set them.
+func (m *structCtx) removeFields(units []migrationUnit) {
+	var order []token.Position
+	for pos := range m.fields {
+		order = append(order, pos)
+	}
+	sort.Slice(order, func(i, j int) bool { return positionLess(order[i], order[j]) })
+
+	for _, fp := range order {
+		field := m.fields[fp]
+		var dels []finding
+		if field.kept == "" {
+			if e, ok := m.fieldDecl(field); ok {
+				dels = append(dels, finding{pos: m.fset.Position(field.decl.Pos()), text: fmt.Sprintf("removed field %s.%s", field.strct, field.name), edit: e})
+			} else {
+				field.kept = "it shares its line or declaration with other fields"
+			}
+		}
+		var values []ast.Expr // expressions that disappear with the deletions
+		for _, u := range units {
+			if field.kept != "" {
+				break
+			}
+			m.fieldUses(u, fp, field, &dels, &values)
+		}
+		if field.kept == "" {
+			field.kept = m.leavesUnused(units, values, dels)
+		}
+		if field.kept != "" {
+			log.Printf("[KEEP] %s:%d: field %s.%s is not removed: %s", fp.Filename, fp.Line, field.strct, field.name, field.kept)
+			continue
+		}
+		for _, d := range dels {
+			m.findings[d.pos.Filename] = append(m.findings[d.pos.Filename], d)
+			m.removed[d.pos.Filename] = append(m.removed[d.pos.Filename], d.edit)
+		}
+	}
+}
+
+// fieldDecl returns the deletion of field's declaration, which must be the only
+// field on its lines.
+func (m *structCtx) fieldDecl(field *ctxField) (edit, bool) {
+	if field.decl == nil || len(field.decl.Names) != 1 {
+		return edit{}, false
+	}
+	list := field.owner.Fields.List
+	prev, next := field.owner.Fields.Opening, field.owner.Fields.Closing
+	for i, fld := range list {
+		if fld == field.decl {
+			if i > 0 {
+				prev = list[i-1].End()
+			}
+			if i+1 < len(list) {
+				next = list[i+1].Pos()
+			}
+		}
+	}
+	from, to := field.decl.Pos(), field.decl.End()
+	if field.decl.Doc != nil {
+		from = field.decl.Doc.Pos()
+	}
+	if field.decl.Comment != nil {
+		to = field.decl.Comment.End()
+	}
+	return m.ownLines(prev, from, to, next)
+}
+
+// fieldUses classifies the uses of the field at fp in u. Keyed literal entries and
+// plain assignments are added to dels; any other use outside the migrated methods
+// keeps the field.
+func (m *structCtx) fieldUses(u migrationUnit, fp token.Position, field *ctxField, dels *[]finding, values *[]ast.Expr) {
+	info := u.pkg.TypesInfo
+	migratedRead := map[*ast.SelectorExpr]bool{}
+	for key, meth := range m.methods {
+		if _, skip := m.excluded[key]; !skip && meth.field == fp {
+			for _, sel := range meth.reads {
+				migratedRead[sel] = true
+			}
+		}
+	}
+	isField := func(id *ast.Ident) bool {
+		obj := info.Uses[id]
+		return obj != nil && m.fset.Position(obj.Pos()) == fp
+	}
+	keep := func(n ast.Node, why string) {
+		if field.kept == "" {
+			p := m.fset.Position(n.Pos())
+			field.kept = fmt.Sprintf("%s at %s:%d", why, p.Filename, p.Line)
+		}
+	}
+
+	var stack []ast.Node
+	ast.Inspect(u.file, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		switch node := n.(type) {
+		case *ast.CompositeLit:
+			st, _ := typeUnderlying(info.TypeOf(node)).(*types.Struct)
+			if st == nil || len(node.Elts) == 0 {
+				return true
+			}
+			if _, keyed := node.Elts[0].(*ast.KeyValueExpr); keyed {
+				for i, elt := range node.Elts {
+					kv := elt.(*ast.KeyValueExpr)
+					if id, ok := kv.Key.(*ast.Ident); ok && isField(id) {
+						*dels = append(*dels, finding{pos: m.fset.Position(kv.Pos()), text: "dropped " + types.ExprString(kv), edit: m.element(node, i)})
+						*values = append(*values, kv.Value)
+					}
+				}
+				return true
+			}
+			for i := 0; i < st.NumFields(); i++ {
+				if m.fset.Position(st.Field(i).Pos()) == fp {
+					keep(node, "it is set positionally")
+				}
+			}
+		case *ast.Ident:
+			if !isField(node) {
+				return true
+			}
+			if len(stack) >= 2 {
+				if kv, ok := stack[len(stack)-2].(*ast.KeyValueExpr); ok && kv.Key == node {
+					return true // handled with its composite literal
+				}
+			}
+			if len(stack) < 3 {
+				keep(node, "it is used")
+				return true
+			}
+			sel, ok := stack[len(stack)-2].(*ast.SelectorExpr)
+			if ok && migratedRead[sel] {
+				return true
+			}
+			if as, ok := stack[len(stack)-3].(*ast.AssignStmt); ok && len(as.Lhs) == 1 && as.Lhs[0] == sel && as.Tok == token.ASSIGN {
+				if hasCall(as.Rhs[0]) {
+					keep(as, "it is assigned the result of a call")
+				} else if e, ok := m.statement(stack[:len(stack)-3], as); ok {
+					*dels = append(*dels, finding{pos: m.fset.Position(as.Pos()), text: "dropped " + types.ExprString(sel) + " = " + types.ExprString(as.Rhs[0]), edit: e})
+					*values = append(*values, as.Rhs[0])
+				} else {
+					keep(as, "it is assigned next to other statements")
+				}
+				return true
+			}
+			keep(node, "it is read")
+		}
+		return true
+	})
+}
+
+// element returns the deletion of lit.Elts[i] with its separator.
+func (m *structCtx) element(lit *ast.CompositeLit, i int) edit {
+	elt := lit.Elts[i]
+	prev, next := lit.Lbrace, lit.Rbrace
+	if i > 0 {
+		prev = lit.Elts[i-1].End()
+	}
+	if i+1 < len(lit.Elts) {
+		next = lit.Elts[i+1].Pos()
+	}
+	if e, ok := m.ownLines(prev, elt.Pos(), elt.End(), next); ok {
+		return e
+	}
+	switch {
+	case i+1 < len(lit.Elts):
+		return edit{start: m.offset(elt.Pos()), end: m.offset(next)}
+	case i > 0:
+		return edit{start: m.offset(prev), end: m.offset(elt.End())}
+	}
+	return edit{start: m.offset(elt.Pos()), end: m.offset(elt.End())}
+}
+
+// statement returns the deletion of stmt, whose ancestors are stack, if it is a
+// statement of a block on lines of its own.
+func (m *structCtx) statement(stack []ast.Node, stmt ast.Stmt) (edit, bool) {
+	block, ok := stack[len(stack)-1].(*ast.BlockStmt)
+	if !ok {
+		return edit{}, false
+	}
+	prev, next := block.Lbrace, block.Rbrace
+	for i, s := range block.List {
+		if s == stmt {
+			if i > 0 {
+				prev = block.List[i-1].End()
+			}
+			if i+1 < len(block.List) {
+				next = block.List[i+1].Pos()
+			}
+		}
+	}
+	return m.ownLines(prev, stmt.Pos(), stmt.End(), next)
+}
+
+func hasCall(e ast.Expr) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if _, ok := n.(*ast.CallExpr); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// leavesUnused returns why deleting dels would break the build, if values (the
+// expressions deleted) hold the last use of a local variable. Uses added by
+// rewriteCalls count.
+func (m *structCtx) leavesUnused(units []migrationUnit, values []ast.Expr, dels []finding) string {
+	deleted := func(p token.Position) bool {
+		for _, d := range dels {
+			if d.pos.Filename == p.Filename && p.Offset >= d.edit.start && p.Offset < d.edit.end {
+				return true
+			}
+		}
+		for _, e := range m.removed[p.Filename] {
+			if p.Offset >= e.start && p.Offset < e.end {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range values {
+		filename := m.fset.Position(v.Pos()).Filename
+		var info *types.Info
+		for _, u := range units {
+			if m.fset.File(u.file.Pos()).Name() == filename {
+				info = u.pkg.TypesInfo
+			}
+		}
+		var why string
+		ast.Inspect(v, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok || why != "" {
+				return why == ""
+			}
+			obj, ok := info.Uses[id].(*types.Var)
+			if !ok || obj.IsField() || obj.Parent() == nil || obj.Parent() == obj.Pkg().Scope() || m.params[m.fset.Position(obj.Pos())] {
+				return true
+			}
+			for use, o := range info.Uses {
+				if o == obj && !deleted(m.fset.Position(use.Pos())) {
+					return true
+				}
+			}
+			for _, a := range m.args {
+				if a.name != obj.Name() || a.pkg != obj.Pkg() {
+					continue
+				}
+				if _, o := a.pkg.Scope().Innermost(a.pos).LookupParent(a.name, a.pos); o == obj {
+					return true
+				}
+			}
+			why = "it would leave " + obj.Name() + " unused"
+			return false
+		})
+		if why != "" {
+			return why
+		}
+	}
+	return ""
+}
+
+func positionLess(a, b token.Position) bool {
+	if a.Filename != b.Filename {
+		return a.Filename < b.Filename
+	}
+	return a.Offset < b.Offset
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintStructContexts(t *testing.T) {
+	srcs := map[string]map[string]string{
+		"example.com/svc": {"svc.go": `package svc
+
+import "context"
+
+type Server struct {
+	ctx  context.Context
+	name string
+}
+
+func New(ctx context.Context, name string) *Server {
+	return &Server{
+		ctx:  ctx,
+		name: name,
+	}
+}
+
+func (s *Server) Serve() error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.handle(s.name)
+}
+
+func (s *Server) handle(name string) error {
+	return work(s.ctx, name)
+}
+
+func work(ctx context.Context, name string) error { return nil }
+
+// Job's method shares its name with context.Context.Done, so it keeps its signature.
+type Job struct {
+	ctx context.Context
+}
+
+func (j Job) Done() <-chan struct{} { return j.ctx.Done() }
+`},
+		"example.com/app": {"app.go": `package app
+
+import (
+	"context"
+
+	"example.com/svc"
+)
+
+func main() {
+	ctx := context.Background()
+	srv := svc.New(ctx, "a")
+	srv.Serve()
+}
+`},
+	}
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc", "example.com/app"}, srcs)
+
+	reports, results := lintStructContexts(pkgs, true)
+	var texts []string
+	for _, r := range reports {
+		texts = append(texts, r.text)
+	}
+	assert.Equal(t, []string{
+		"Server stores a context.Context in field ctx; pass it to the methods that need it instead",
+		"Job stores a context.Context in field ctx; pass it to the methods that need it instead",
+	}, texts)
+
+	out := map[string]string{}
+	for _, res := range results {
+		var edits []edit
+		for _, f := range res.findings {
+			edits = append(edits, f.edit)
+		}
+		src := srcs["example.com/svc"][res.filename] + srcs["example.com/app"][res.filename]
+		patched, err := applyEdits([]byte(src), edits)
+		assert.NoError(t, err)
+		out[res.filename] = string(patched)
+	}
+
+	assert.Equal(t, `package svc
+
+import "context"
+
+type Server struct {
+	name string
+}
+
+func New(ctx context.Context, name string) *Server {
+	return &Server{
+		name: name,
+	}
+}
+
+func (s *Server) Serve(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.handle(ctx, s.name)
+}
+
+func (s *Server) handle(ctx context.Context, name string) error {
+	return work(ctx, name)
+}
+
+func work(ctx context.Context, name string) error { return nil }
+
+// Job's method shares its name with context.Context.Done, so it keeps its signature.
+type Job struct {
+	ctx context.Context
+}
+
+func (j Job) Done() <-chan struct{} { return j.ctx.Done() }
+`, out["svc.go"])
+	assert.Equal(t, `package app
+
+import (
+	"context"
+
+	"example.com/svc"
+)
+
+func main() {
+	ctx := context.Background()
+	srv := svc.New(ctx, "a")
+	srv.Serve(ctx)
+}
+`, out["app.go"])
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// Directives that keep context.TODO() calls as they are. Text after the directive
+// is the justification, reported with the suppressed sites:
+//
+//	use(context.TODO()) //ctxast:ignore runs after the request is done
+const (
+	ignoreDirective     = "ctxast:ignore"      // the statement or declaration it is attached to
+	ignoreFileDirective = "ctxast:ignore-file" // the whole file; only honoured before the package clause
+)
+
+// suppressions are the //ctxast:ignore directives of a file.
+type suppressions struct {
+	file    bool
+	fileWhy string
+	nodes   []suppressedNode
+}
+
+// suppressedNode is the extent of a node carrying a //ctxast:ignore directive.
+type suppressedNode struct {
+	pos, end token.Pos
+	why      string
+}
+
+// parseDirective returns the name and justification of a //ctxast: comment. Like
+// //go: directives, there is no space after the slashes.
+func parseDirective(c *ast.Comment) (name, why string, ok bool) {
+	text, ok := strings.CutPrefix(c.Text, "//")
+	if !ok || !strings.HasPrefix(text, "ctxast:") {
+		return "", "", false
+	}
+	name, why, _ = strings.Cut(text, " ")
+	return name, strings.TrimSpace(why), true
+}
+
+// fileSuppressions collects the directives of file. A //ctxast:ignore comment
+// covers the node ast.CommentMap attaches it to: the statement it trails or
+// precedes, or a whole function when it is part of the doc comment.
+func fileSuppressions(fset *token.FileSet, file *ast.File) *suppressions {
+	s := &suppressions{}
+	for _, cg := range file.Comments {
+		if cg.Pos() >= file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if name, why, ok := parseDirective(c); ok && name == ignoreFileDirective {
+				s.file, s.fileWhy = true, why
+			}
+		}
+	}
+	for node, groups := range ast.NewCommentMap(fset, file, file.Comments) {
+		if _, ok := node.(*ast.File); ok {
+			continue
+		}
+		for _, cg := range groups {
+			for _, c := range cg.List {
+				if name, why, ok := parseDirective(c); ok && name == ignoreDirective {
+					s.nodes = append(s.nodes, suppressedNode{node.Pos(), node.End(), why})
+				}
+			}
+		}
+	}
+	return s
+}
+
+// suppressed reports whether a directive covers pos and returns the justification
+// of the innermost one.
+func (s *suppressions) suppressed(pos token.Pos) (string, bool) {
+	var best *suppressedNode
+	for i, n := range s.nodes {
+		if pos >= n.pos && pos < n.end && (best == nil || n.end-n.pos < best.end-best.pos) {
+			best = &s.nodes[i]
+		}
+	}
+	if best != nil {
+		return best.why, true
+	}
+	return s.fileWhy, s.file
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuppressions(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/svc"}, map[string]map[string]string{
+		"example.com/svc": {
+			"svc.go": `package svc
+
+import "context"
+
+func Run(ctx context.Context) {
+	use(context.TODO()) //ctxast:ignore detached on purpose
+	//ctxast:ignore
+	use(context.TODO())
+	use(context.TODO())
+	// ctxast:ignore is not a directive with a space
+	use(context.TODO())
+}
+
+// Background is never called with a request context.
+//
+//ctxast:ignore startup only
+func Background() {
+	use(context.TODO())
+}
+
+func use(ctx context.Context) {}
+`,
+			"gen.go": `// Copyright notice.
+
+//ctxast:ignore-file legacy code
+package svc
+
+import "context"
+
+func Legacy(ctx context.Context) {
+	use(context.TODO())
+}
+`,
+		},
+	})
+	var files []fileResult
+	for _, file := range pkgs[0].Syntax {
+		findings, unresolved := processFile(pkgs[0], file, nil, newFuncIndex(pkgs))
+		files = append(files, fileResult{filename: fset.Position(file.Pos()).Filename, findings: findings, unresolved: unresolved})
+	}
+	results := mergedResults{}
+	results.add(files)
+	all := results.results()
+	if !assert.Len(t, all, 2) {
+		return
+	}
+	gen, svc := all[0], all[1]
+
+	var lines []int
+	for _, f := range svc.findings {
+		lines = append(lines, f.pos.Line)
+	}
+	assert.Equal(t, []int{9, 11}, lines)
+	var suppressed []unresolvedSite
+	for _, u := range svc.unresolved {
+		u.pos = token.Position{Line: u.pos.Line}
+		suppressed = append(suppressed, u)
+	}
+	assert.Equal(t, []unresolvedSite{
+		{pos: token.Position{Line: 6}, fn: "svc.Run", reason: reasonSuppressed, detail: "detached on purpose"},
+		{pos: token.Position{Line: 8}, fn: "svc.Run", reason: reasonSuppressed},
+		{pos: token.Position{Line: 18}, fn: "svc.Background", reason: reasonSuppressed, detail: "startup only"},
+	}, suppressed)
+	assert.Empty(t, gen.findings)
+	if assert.Len(t, gen.unresolved, 1) {
+		assert.Equal(t, "legacy code", gen.unresolved[0].detail)
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, writeReport(&out, buildReport(all[1:], true)))
+	assert.Contains(t, out.String(), `{
+      "file": "svc.go",
+      "line": 6,
+      "column": 6,
+      "function": "svc.Run",
+      "status": "suppressed",
+      "justification": "detached on purpose"
+    },`)
+	assert.Contains(t, out.String(), `"line": 9,
+      "column": 6,
+      "function": "svc.Run",
+      "status": "replaceable",
+      "replacement": "ctx"`)
+}
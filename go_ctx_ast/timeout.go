@@ -0,0 +1,540 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// deadlineFuncs are the context functions that set a deadline.
+var deadlineFuncs = map[string]bool{
+	"WithTimeout":       true,
+	"WithTimeoutCause":  true,
+	"WithDeadline":      true,
+	"WithDeadlineCause": true,
+}
+
+// parseTimeoutRules parses -timeout rules of the form FUNC=DURATION, where FUNC is
+// the function as types.Func.FullName spells it: "net/http.Get",
+// "(*database/sql.DB).QueryContext".
+func parseTimeoutRules(specs []string) (map[string]time.Duration, error) {
+	rules := map[string]time.Duration{}
+	for _, spec := range specs {
+		fn, dur, ok := strings.Cut(spec, "=")
+		if !ok || fn == "" {
+			return nil, fmt.Errorf("-timeout %q: want FUNC=DURATION", spec)
+		}
+		d, err := time.ParseDuration(dur)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("-timeout %q: bad duration %q", spec, dur)
+		}
+		rules[fn] = d
+	}
+	return rules, nil
+}
+
+// durationExpr spells d as a constant expression of package time, imported as name:
+// 5*time.Second, 1500*time.Millisecond, time.Minute.
+func durationExpr(d time.Duration, name string) string {
+	units := []struct {
+		d    time.Duration
+		name string
+	}{
+		{time.Hour, "Hour"}, {time.Minute, "Minute"}, {time.Second, "Second"},
+		{time.Millisecond, "Millisecond"}, {time.Microsecond, "Microsecond"}, {time.Nanosecond, "Nanosecond"},
+	}
+	for _, u := range units {
+		if d%u.d != 0 {
+			continue
+		}
+		if d == u.d {
+			return name + "." + u.name
+		}
+		return fmt.Sprintf("%d*%s.%s", d/u.d, name, u.name)
+	}
+	panic("unreachable")
+}
+
+// runTimeouts implements -timeout and returns the exit status.
+func runTimeouts(configs []buildConfig, patterns []string, changed changedLines) int {
+	rules, err := parseTimeoutRules(flagTimeouts)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	pkgs, status := loadWholeProgram("-timeout", configs, patterns, false)
+	if pkgs == nil {
+		return status
+	}
+	return applyResults(insertTimeouts(pkgs, rules, changed))
+}
+
+// timeouts computes the edits of -timeout.
+type timeouts struct {
+	editSet
+	rules    map[string]time.Duration
+	changed  changedLines
+	timeName map[string]string // file name -> name "time" is imported under
+}
+
+// insertTimeouts wraps the calls to the functions of rules in pkgs that have no
+// deadline yet:
+//
+//	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+//	defer cancel()
+//	rows, err := db.QueryContext(timeoutCtx, q)
+//
+// The context argument is replaced rather than ctx reassigned, so the rest of the
+// function keeps the context it had. A call counts as having a deadline if its
+// context variable was assigned from context.WithTimeout or WithDeadline earlier in
+// the function. For functions taking an *http.Request instead, such as
+// (*http.Client).Do, the request is passed as req.WithContext(timeoutCtx). Calls
+// whose result outlives the function, where the deferred cancel would end the
+// context of rows or a response body still to be read, are left (see resultOutlives).
+func insertTimeouts(pkgs []*packages.Package, rules map[string]time.Duration, changed changedLines) []fileResult {
+	if len(pkgs) == 0 {
+		return nil
+	}
+	t := &timeouts{editSet: newEditSet(pkgs[0].Fset), rules: rules, changed: changed, timeName: map[string]string{}}
+	for _, u := range migrationUnits(pkgs) {
+		filename := u.pkg.Fset.File(u.file.Pos()).Name()
+		if isExcluded(filename) || (!flagIncludeGenerated && ast.IsGenerated(u.file)) {
+			continue
+		}
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+		for _, d := range u.file.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Body != nil {
+				t.funcDecl(u.pkg, u.file, src, fd)
+			}
+		}
+	}
+	return t.results()
+}
+
+// funcDecl wraps the matching calls in fd, including those in its function literals.
+func (t *timeouts) funcDecl(pkg *packages.Package, file *ast.File, src []byte, fd *ast.FuncDecl) {
+	info := pkg.TypesInfo
+	added := map[string]bool{} // names declared by earlier wrappers in fd
+	var stack []ast.Node
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		fn := calledFunc(info, call)
+		if fn == nil {
+			return true
+		}
+		d, ok := t.rules[fn.Origin().FullName()]
+		if !ok {
+			return true
+		}
+		pos := t.fset.Position(call.Pos())
+		if t.changed != nil && !t.changed.contains(pos.Filename, pos.Line) {
+			return true
+		}
+		if why := t.wrap(pkg, file, src, fd, stack, call, d, added); why != "" {
+			log.Printf("[SKIP] %s:%d: %s: %s", pos.Filename, pos.Line, fn.Name(), why)
+		}
+		return true
+	})
+}
+
+// wrap wraps call, the last node of stack, or says why it does not.
+func (t *timeouts) wrap(pkg *packages.Package, file *ast.File, src []byte, fd *ast.FuncDecl, stack []ast.Node, call *ast.CallExpr, d time.Duration, added map[string]bool) string {
+	info := pkg.TypesInfo
+
+	// the argument carrying the context
+	var arg, base ast.Expr
+	request := false
+	for _, a := range call.Args {
+		typ := info.TypeOf(a)
+		if kind, ok := isContextType(typ); ok && kind == ctxValue {
+			arg, base = a, a
+			break
+		}
+		if isHTTPRequest(typ) {
+			arg, request = a, true
+			base = a
+			if c, ok := withContextArg(info, a); ok {
+				base = c
+			}
+			break
+		}
+	}
+	if arg == nil {
+		return "no context or *http.Request argument"
+	}
+	if hasDeadline(info, fd, base, call.Pos()) {
+		return ""
+	}
+	if !sideEffectFree(base) {
+		return "the context argument is not a variable or a field"
+	}
+
+	// the statement to insert before, and no loop around it in the same function
+	var stmt ast.Stmt
+	i := len(stack) - 2
+	for ; i >= 0 && stmt == nil; i-- {
+		switch stack[i].(type) {
+		case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+			stmt, _ = stack[i+1].(ast.Stmt)
+		case *ast.FuncLit:
+			return "not part of a statement"
+		}
+	}
+	if stmt == nil {
+		return "not part of a statement"
+	}
+	if how := resultOutlives(info, fd, stack, stmt); how != "" {
+		return how + ", where it outlives the deferred cancel"
+	}
+outer:
+	for ; i >= 0; i-- {
+		switch stack[i].(type) {
+		case *ast.FuncLit:
+			break outer
+		case *ast.ForStmt, *ast.RangeStmt:
+			return "in a loop, where the deferred cancel calls would pile up"
+		}
+	}
+	start := t.offset(stmt.Pos())
+	indent, ok := lineIndent(src, start)
+	if !ok {
+		return "shares its line with other code"
+	}
+
+	// names
+	scope := pkg.Types.Scope().Innermost(stmt.Pos())
+	visible := func(name string) types.Object {
+		if scope == nil {
+			return nil
+		}
+		_, obj := scope.LookupParent(name, stmt.Pos())
+		return obj
+	}
+	fresh := func(base string) string {
+		name := base
+		for i := 2; added[name] || visible(name) != nil || declaresName(info, fd, name); i++ {
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		added[name] = true
+		return name
+	}
+	// an import must not be shadowed where its name is used
+	shadowed := func(path string) bool {
+		name, imported := importName(file, path)
+		if !imported {
+			name = defaultImportName(path)
+		}
+		obj := visible(name)
+		_, isPkg := obj.(*types.PkgName)
+		return obj != nil && !(imported && isPkg)
+	}
+	for _, path := range []string{"context", "time"} {
+		if shadowed(path) {
+			return path + " is shadowed"
+		}
+	}
+	ctxName := fresh("timeoutCtx")
+	cancelName := fresh("cancel")
+	contextPkg := t.contextName(file)
+	timePkg := t.importTime(file)
+
+	from := types.ExprString(base)
+	if request && base == arg {
+		from += ".Context()"
+	}
+	replacement := ctxName
+	if request {
+		replacement = types.ExprString(ast.Unparen(arg)) + ".WithContext(" + ctxName + ")"
+		if base != arg {
+			// already req.WithContext(c): replace c
+			arg = base
+			replacement = ctxName
+		}
+	}
+	wrapper := fmt.Sprintf("%s, %s := %s.WithTimeout(%s, %s)\n%sdefer %s()\n%s",
+		ctxName, cancelName, contextPkg, from, durationExpr(d, timePkg), indent, cancelName, indent)
+	t.note(call.Pos(), fmt.Sprintf("%s now runs with a %s timeout", types.ExprString(call.Fun), d), edit{start: start, end: start, text: wrapper})
+	t.note(arg.Pos(), "", edit{start: t.offset(arg.Pos()), end: t.offset(arg.End()), text: replacement})
+	return ""
+}
+
+// resultOutlives says how the result of the call ending stack, in stmt, may be used
+// after fd returns: it is returned, passed on to another call or a channel, or
+// assigned to a variable that is not local, directly or through a local variable
+// later. Results that cannot hold on to the context, like errors and numbers, do
+// not count; nor do locals passed to calls, like json.NewDecoder(resp.Body), which
+// mostly read them right away. A call in a go or defer statement, or in a function
+// literal one runs, outlives fd itself.
+func resultOutlives(info *types.Info, fd *ast.FuncDecl, stack []ast.Node, stmt ast.Stmt) string {
+	switch stmt.(type) {
+	case *ast.GoStmt:
+		return "it runs in a go statement"
+	case *ast.DeferStmt:
+		return "it runs in a defer statement"
+	}
+	for j := len(stack) - 2; j >= 2; j-- {
+		if _, ok := stack[j].(*ast.FuncLit); !ok {
+			continue
+		}
+		switch p := stack[j-2].(type) {
+		case *ast.GoStmt:
+			if p.Call == stack[j-1] {
+				return "it runs in a function literal of a go statement"
+			}
+		case *ast.DeferStmt:
+			if p.Call == stack[j-1] {
+				return "it runs in a function literal of a defer statement"
+			}
+		}
+	}
+
+	// the result, or a field of it
+	var e ast.Expr = stack[len(stack)-1].(*ast.CallExpr)
+	i := len(stack) - 2
+	for ; i > 0; i-- {
+		p, ok := stack[i].(ast.Expr)
+		if !ok {
+			break
+		}
+		if _, ok := p.(*ast.ParenExpr); !ok {
+			if sel, ok := p.(*ast.SelectorExpr); !ok || sel.X != e {
+				break
+			}
+		}
+		e = p
+	}
+	var locals []types.Object
+	switch p := stack[i].(type) {
+	case *ast.ReturnStmt:
+		return "its result is returned"
+	case *ast.CallExpr:
+		if p.Fun != e {
+			return "its result is passed to " + types.ExprString(p.Fun)
+		}
+	case *ast.SendStmt:
+		if p.Value == e {
+			return "its result is sent on a channel"
+		}
+	case *ast.AssignStmt:
+		for k, lhs := range p.Lhs {
+			if len(p.Lhs) == len(p.Rhs) && p.Rhs[k] != e {
+				continue
+			}
+			if !holdsContext(info.TypeOf(lhs)) {
+				continue
+			}
+			if nonLocal(info, lhs) {
+				return "its result is assigned to " + types.ExprString(lhs)
+			}
+			if id := ast.Unparen(lhs).(*ast.Ident); id.Name != "_" {
+				locals = append(locals, info.ObjectOf(id))
+			}
+		}
+	case *ast.ValueSpec:
+		for k, name := range p.Names {
+			if len(p.Names) == len(p.Values) && p.Values[k] != e {
+				continue
+			}
+			if obj := info.Defs[name]; obj != nil && name.Name != "_" && holdsContext(obj.Type()) {
+				locals = append(locals, obj)
+			}
+		}
+	}
+	if len(locals) == 0 {
+		return ""
+	}
+
+	// later uses of the locals: the local itself or a field of it
+	local := func(e ast.Expr) types.Object {
+		for {
+			switch x := ast.Unparen(e).(type) {
+			case *ast.SelectorExpr:
+				e = x.X
+				continue
+			case *ast.Ident:
+				for _, obj := range locals {
+					if info.Uses[x] == obj {
+						return obj
+					}
+				}
+			}
+			return nil
+		}
+	}
+	var how string
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if how != "" || n == nil {
+			return false
+		}
+		if n.Pos() < stmt.End() {
+			return n.End() > stmt.End() // look into the blocks around stmt
+		}
+		switch n := n.(type) {
+		case *ast.ReturnStmt:
+			for _, r := range n.Results {
+				if obj := local(r); obj != nil && holdsContext(info.TypeOf(r)) {
+					how = "its result " + obj.Name() + " is returned"
+				}
+			}
+		case *ast.SendStmt:
+			if obj := local(n.Value); obj != nil {
+				how = "its result " + obj.Name() + " is sent on a channel"
+			}
+		case *ast.GoStmt:
+			for _, a := range n.Call.Args {
+				if obj := local(a); obj != nil {
+					how = "its result " + obj.Name() + " is passed to a goroutine"
+				}
+			}
+		case *ast.AssignStmt:
+			for k, rhs := range n.Rhs {
+				obj := local(rhs)
+				if obj == nil || len(n.Lhs) != len(n.Rhs) || !holdsContext(info.TypeOf(rhs)) {
+					continue
+				}
+				if nonLocal(info, n.Lhs[k]) {
+					how = "its result " + obj.Name() + " is assigned to " + types.ExprString(n.Lhs[k])
+				}
+			}
+		}
+		return how == ""
+	})
+	return how
+}
+
+// nonLocal reports whether assigning to lhs stores a value outside the function:
+// in a field, an element, or a package-level variable.
+func nonLocal(info *types.Info, lhs ast.Expr) bool {
+	id, ok := ast.Unparen(lhs).(*ast.Ident)
+	if !ok {
+		return true
+	}
+	obj := info.ObjectOf(id)
+	return obj != nil && obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope()
+}
+
+// holdsContext reports whether a value of type t may hold on to a context, unlike
+// errors and values of basic types.
+func holdsContext(t types.Type) bool {
+	if t == nil {
+		return false
+	}
+	if _, basic := t.Underlying().(*types.Basic); basic {
+		return false
+	}
+	return !types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// importTime returns the name file imports "time" under, adding the import if needed.
+func (t *timeouts) importTime(file *ast.File) string {
+	filename := t.fset.File(file.Pos()).Name()
+	if name, ok := t.timeName[filename]; ok {
+		return name
+	}
+	e, name, add := addImportEdit(t.fset, file, "time")
+	if add {
+		t.note(file.Name.Pos(), `import "time"`, e)
+	}
+	t.timeName[filename] = name
+	return name
+}
+
+// isHTTPRequest reports whether t is *net/http.Request.
+func isHTTPRequest(t types.Type) bool {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "net/http" && named.Obj().Name() == "Request"
+}
+
+// withContextArg returns c if e is req.WithContext(c).
+func withContextArg(info *types.Info, e ast.Expr) (ast.Expr, bool) {
+	call, ok := ast.Unparen(e).(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return nil, false
+	}
+	fn := calledFunc(info, call)
+	if fn == nil || fn.Name() != "WithContext" {
+		return nil, false
+	}
+	if recv := fn.Type().(*types.Signature).Recv(); recv == nil || !isHTTPRequest(recv.Type()) {
+		return nil, false
+	}
+	return call.Args[0], true
+}
+
+// sideEffectFree reports whether evaluating e earlier than the call it is an
+// argument of cannot change what it evaluates to: a variable, a field, a literal,
+// or an accessor such as r.Context().
+func sideEffectFree(e ast.Expr) bool {
+	switch e := ast.Unparen(e).(type) {
+	case *ast.Ident, *ast.BasicLit:
+		return true
+	case *ast.SelectorExpr:
+		return sideEffectFree(e.X)
+	case *ast.CallExpr:
+		sel, ok := e.Fun.(*ast.SelectorExpr)
+		return ok && len(e.Args) == 0 && sideEffectFree(sel.X)
+	}
+	return false
+}
+
+// hasDeadline reports whether ctx, a variable, is assigned from a context function
+// setting a deadline in fd before pos.
+func hasDeadline(info *types.Info, fd *ast.FuncDecl, ctx ast.Expr, pos token.Pos) bool {
+	id, ok := ast.Unparen(ctx).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	obj := info.Uses[id]
+	if obj == nil {
+		return false
+	}
+	setsDeadline := func(e ast.Expr) bool {
+		call, ok := ast.Unparen(e).(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		fn := calledFunc(info, call)
+		return fn != nil && fn.Pkg() != nil && fn.Pkg().Path() == "context" && deadlineFuncs[fn.Name()]
+	}
+	assigns := func(lhs ast.Expr) bool {
+		id, ok := lhs.(*ast.Ident)
+		return ok && (info.Defs[id] == obj || info.Uses[id] == obj)
+	}
+	found := false
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		if found || n == nil || n.Pos() >= pos {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			found = len(n.Lhs) > 0 && len(n.Rhs) == 1 && assigns(n.Lhs[0]) && setsDeadline(n.Rhs[0])
+		case *ast.ValueSpec:
+			found = len(n.Names) > 0 && len(n.Values) == 1 && assigns(n.Names[0]) && setsDeadline(n.Values[0])
+		}
+		return !found
+	})
+	return found
+}
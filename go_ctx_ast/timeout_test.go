@@ -0,0 +1,167 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurationExpr(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		5 * time.Second:         "5*time.Second",
+		time.Minute:             "time.Minute",
+		1500 * time.Millisecond: "1500*time.Millisecond",
+		90 * time.Minute:        "90*time.Minute",
+	} {
+		assert.Equal(t, want, durationExpr(d, "time"))
+	}
+}
+
+func TestInsertTimeouts(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "store.go")
+	src := `package store
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+)
+
+func Get(ctx context.Context, db *sql.DB, c *http.Client, req *http.Request) error {
+	cancel := func() {}
+	defer cancel()
+	if _, err := db.QueryContext(ctx, "a"); err != nil {
+		return err
+	}
+	_, err := db.QueryContext(ctx, "b")
+	c.Do(req)
+	for range 3 {
+		db.ExecContext(ctx, "c")
+	}
+	return err
+}
+
+func Bounded(ctx context.Context, db *sql.DB) {
+	ctx, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	db.QueryContext(ctx, "a")
+}
+
+// the deferred cancel would end the context while the results are still read
+func Rows(ctx context.Context, db *sql.DB) (*sql.Rows, error) {
+	return db.QueryContext(ctx, "d")
+}
+
+func Body(c *http.Client, req *http.Request) (io.ReadCloser, error) {
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// the goroutines and the deferred call run after the deferred cancel
+func Async(ctx context.Context, db *sql.DB) {
+	go db.ExecContext(ctx, "e")
+	defer db.ExecContext(ctx, "f")
+	go func() {
+		db.ExecContext(ctx, "g")
+	}()
+	defer func() {
+		db.ExecContext(ctx, "h")
+	}()
+}
+`
+	assert.NoError(t, os.WriteFile(filename, []byte(src), 0o644))
+	fset := token.NewFileSet()
+	pkgs := testPackages(t, fset, []string{"example.com/store"}, map[string]map[string]string{
+		"example.com/store": {filename: src},
+	})
+	rules, err := parseTimeoutRules([]string{
+		"(*database/sql.DB).QueryContext=5s",
+		"(*database/sql.DB).ExecContext=5s",
+		"(*net/http.Client).Do=1m",
+	})
+	assert.NoError(t, err)
+
+	results := insertTimeouts(pkgs, rules, nil)
+	if !assert.Len(t, results, 1) {
+		return
+	}
+	var edits []edit
+	for _, f := range results[0].findings {
+		edits = append(edits, f.edit)
+	}
+	out, err := applyEdits([]byte(src), edits)
+	assert.NoError(t, err)
+	assert.Equal(t, `package store
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"time"
+)
+
+func Get(ctx context.Context, db *sql.DB, c *http.Client, req *http.Request) error {
+	cancel := func() {}
+	defer cancel()
+	timeoutCtx, cancel2 := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel2()
+	if _, err := db.QueryContext(timeoutCtx, "a"); err != nil {
+		return err
+	}
+	timeoutCtx2, cancel3 := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel3()
+	_, err := db.QueryContext(timeoutCtx2, "b")
+	timeoutCtx3, cancel4 := context.WithTimeout(req.Context(), time.Minute)
+	defer cancel4()
+	c.Do(req.WithContext(timeoutCtx3))
+	for range 3 {
+		db.ExecContext(ctx, "c")
+	}
+	return err
+}
+
+func Bounded(ctx context.Context, db *sql.DB) {
+	ctx, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	db.QueryContext(ctx, "a")
+}
+
+// the deferred cancel would end the context while the results are still read
+func Rows(ctx context.Context, db *sql.DB) (*sql.Rows, error) {
+	return db.QueryContext(ctx, "d")
+}
+
+func Body(c *http.Client, req *http.Request) (io.ReadCloser, error) {
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// the goroutines and the deferred call run after the deferred cancel
+func Async(ctx context.Context, db *sql.DB) {
+	go db.ExecContext(ctx, "e")
+	defer db.ExecContext(ctx, "f")
+	go func() {
+		db.ExecContext(ctx, "g")
+	}()
+	defer func() {
+		db.ExecContext(ctx, "h")
+	}()
+}
+`, string(out))
+
+	_, err = parseTimeoutRules([]string{"(*database/sql.DB).QueryContext"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadGroup is a set of patterns loaded together, from dir.
+type loadGroup struct {
+	dir      string
+	patterns []string
+}
+
+// goWork returns the go.work file the go tool uses in dir: $GOWORK if set
+// ("off" disables workspaces), else the first go.work found walking up from dir.
+func goWork(dir string) string {
+	switch env := os.Getenv("GOWORK"); env {
+	case "off":
+		return ""
+	case "":
+		return findUp(dir, "go.work")
+	default:
+		return env
+	}
+}
+
+// moduleRoot returns the directory of the go.mod owning dir, or "" if none does.
+func moduleRoot(dir string) string {
+	if gomod := findUp(dir, "go.mod"); gomod != "" {
+		return filepath.Dir(gomod)
+	}
+	return ""
+}
+
+// findUp returns the path of the first regular file called name in dir or one of
+// its parents.
+func findUp(dir, name string) string {
+	for {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadGroups splits patterns by the module that owns them, so that files and
+// directories of sibling modules resolve against their own go.mod. Inside a
+// go.work workspace the go tool resolves every module itself, and all patterns
+// load together from dir. Otherwise file= queries and directory patterns of
+// another module load from its root, rewritten relative to it; everything else
+// (import paths, std, ...) loads from dir.
+func loadGroups(dir string, patterns []string) []loadGroup {
+	abs, err := filepath.Abs(dir)
+	if err != nil || goWork(abs) != "" {
+		return []loadGroup{{dir, patterns}}
+	}
+	home := moduleRoot(abs)
+	var groups []loadGroup
+	index := map[string]int{}
+	add := func(dir, pattern string) {
+		i, ok := index[dir]
+		if !ok {
+			i = len(groups)
+			index[dir] = i
+			groups = append(groups, loadGroup{dir: dir})
+		}
+		groups[i].patterns = append(groups[i].patterns, pattern)
+	}
+	for _, p := range patterns {
+		if filename, ok := strings.CutPrefix(p, "file="); ok {
+			if mod := moduleRoot(filepath.Dir(filename)); mod != "" && mod != home {
+				add(mod, p)
+				continue
+			}
+			add(dir, p)
+			continue
+		}
+		if !isLocalPattern(p) {
+			add(dir, p)
+			continue
+		}
+		path, rest := p, ""
+		if strings.HasSuffix(path, "/...") {
+			path, rest = strings.TrimSuffix(path, "/..."), "/..."
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(abs, path)
+		}
+		mod := moduleRoot(path)
+		if mod == "" || mod == home {
+			add(dir, p)
+			continue
+		}
+		rel, err := filepath.Rel(mod, path)
+		if err != nil {
+			add(dir, p)
+			continue
+		}
+		q := "./" + filepath.ToSlash(rel)
+		if rel == "." {
+			q = "."
+		}
+		add(mod, q+rest)
+	}
+	return groups
+}
+
+// isLocalPattern reports whether pattern names a directory, as the go tool
+// decides it: relative to the working directory or absolute.
+func isLocalPattern(pattern string) bool {
+	return pattern == "." || pattern == ".." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") || filepath.IsAbs(pattern)
+}
+
+// loadPackages is packages.Load for patterns that may span several modules: each
+// group of loadGroups loads from its own directory into cfg's FileSet, shared so
+// that positions, and the functions keyed by them, agree across modules.
+func loadPackages(cfg *packages.Config, patterns ...string) ([]*packages.Package, error) {
+	if cfg.Fset == nil {
+		cfg.Fset = token.NewFileSet()
+	}
+	groups := loadGroups(cfg.Dir, patterns)
+	var pkgs []*packages.Package
+	for _, g := range groups {
+		c := *cfg
+		c.Dir = g.dir
+		loaded, err := packages.Load(&c, g.patterns...)
+		if err != nil {
+			if len(groups) > 1 {
+				return nil, fmt.Errorf("%s: %v", g.dir, err)
+			}
+			return nil, err
+		}
+		pkgs = append(pkgs, loaded...)
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadGroups(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		filename := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+	}
+	write("api/go.mod", "module example.com/api\n")
+	write("store/go.mod", "module example.com/store\n")
+	write("store/sql/sql.go", "package sql\n")
+	api, store := filepath.Join(root, "api"), filepath.Join(root, "store")
+	t.Setenv("GOWORK", "")
+
+	patterns := []string{"./...", "../store/...", "../store/sql", "file=" + filepath.Join(store, "sql", "sql.go"), "fmt", filepath.Join(api, "handlers")}
+	assert.Equal(t, []loadGroup{
+		{api, []string{"./...", "fmt", filepath.Join(api, "handlers")}},
+		{store, []string{"./...", "./sql", "file=" + filepath.Join(store, "sql", "sql.go")}},
+	}, loadGroups(api, patterns))
+
+	// a workspace resolves every module from anywhere in it
+	write("go.work", "go 1.24\n\nuse (\n\t./api\n\t./store\n)\n")
+	assert.Equal(t, filepath.Join(root, "go.work"), goWork(api))
+	assert.Equal(t, []loadGroup{{api, patterns}}, loadGroups(api, patterns))
+
+	t.Setenv("GOWORK", "off")
+	assert.Equal(t, "", goWork(api))
+	assert.Len(t, loadGroups(api, patterns), 2)
+
+	assert.Equal(t, store, moduleRoot(filepath.Join(store, "sql")))
+	assert.Equal(t, "", moduleRoot(root))
+}